@@ -0,0 +1,610 @@
+package rego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// binaryMagic begins every module written by SerializeModuleBinary
+var binaryMagic = []byte("RGBN")
+
+// binaryVersion is the version of the binary format. It must be incremented whenever the format changes.
+const binaryVersion = 1
+
+// flagLocations is set in the header of modules serialized with their locations
+const flagLocations = 1
+
+// Term tags
+const (
+	tagNilTerm byte = iota
+	tagNull
+	tagTrue
+	tagFalse
+	tagInt
+	tagNumber
+	tagString
+	tagVar
+	tagRef
+	tagArray
+	tagObject
+	tagSet
+	tagArrayComprehension
+	tagObjectComprehension
+	tagSetComprehension
+	tagCall
+)
+
+// Expression kinds
+const (
+	exprTerm byte = iota
+	exprCall
+	exprSome
+)
+
+// SerializeModuleBinary converts module into a compact binary encoding. Every string (variable names, reference
+// parts, string literals) is stored once in a string table and referred to by index, and integers are stored as
+// varints. If withLocations is true the file, row, column and source text of every node is stored as well, so that
+// errors produced from the deserialized module point to the original source; otherwise, as with
+// SerializeModuleJson, the module should be compiled and checked for correctness before it is serialized.
+func SerializeModuleBinary(module *ast.Module, withLocations bool) ([]byte, error) {
+	if module == nil || module.Package == nil {
+		return nil, fmt.Errorf("module has no package")
+	}
+	enc := &binaryEncoder{
+		buf:       new(bytes.Buffer),
+		index:     make(map[string]uint64),
+		locations: withLocations,
+	}
+	enc.module(module)
+	if enc.err != nil {
+		return nil, enc.err
+	}
+
+	out := new(bytes.Buffer)
+	out.Write(binaryMagic)
+	out.WriteByte(binaryVersion)
+	if withLocations {
+		out.WriteByte(flagLocations)
+	} else {
+		out.WriteByte(0)
+	}
+	writeUvarint(out, uint64(len(enc.table)))
+	for _, s := range enc.table {
+		writeUvarintBytes(out, []byte(s))
+	}
+	out.Write(enc.buf.Bytes())
+	return out.Bytes(), nil
+}
+
+// DeserializeModuleBinary reads a module written by SerializeModuleBinary. Modules compressed with Compress are
+// decompressed first. Malformed or truncated module bodies are reported as a DecodeErr whose Path is the byte offset
+// at which decoding failed.
+func DeserializeModuleBinary(data []byte) (*ast.Module, error) {
+	data, err := Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, binaryMagic) {
+		return nil, fmt.Errorf("not a binary module")
+	}
+	r := bytes.NewReader(data[len(binaryMagic):])
+
+	v, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if v != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary module version %v", v)
+	}
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	dec := &binaryDecoder{r: r, locations: flags&flagLocations != 0}
+	n := dec.uvarint()
+	if dec.err == nil && n > uint64(r.Len()) {
+		return nil, fmt.Errorf("string table of %v entries exceeds remaining %v bytes", n, r.Len())
+	}
+	dec.table = make([]string, 0, n)
+	for i := uint64(0); i < n && dec.err == nil; i++ {
+		s, err := readUvarintBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		dec.table = append(dec.table, string(s))
+	}
+
+	module := dec.module()
+	if dec.err != nil {
+		return nil, NewDecodeError(fmt.Sprintf("byte %v", len(binaryMagic)+dec.pos), dec.err.Error())
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("trailing data after module")
+	}
+	addModuleToRules(module)
+	return module, nil
+}
+
+// binaryEncoder writes the body of a binary module. Strings are interned into table as they are written.
+type binaryEncoder struct {
+	buf       *bytes.Buffer
+	index     map[string]uint64
+	table     []string
+	locations bool
+	err       error
+}
+
+func (e *binaryEncoder) module(m *ast.Module) {
+	e.location(m.Package.Location)
+	e.ref(m.Package.Path)
+
+	e.uvarint(uint64(len(m.Imports)))
+	for _, imp := range m.Imports {
+		e.location(imp.Location)
+		e.term(imp.Path)
+		e.str(string(imp.Alias))
+	}
+
+	e.uvarint(uint64(len(m.Rules)))
+	for _, rule := range m.Rules {
+		e.rule(rule)
+	}
+
+	e.uvarint(uint64(len(m.Comments)))
+	for _, c := range m.Comments {
+		e.location(c.Location)
+		e.str(string(c.Text))
+	}
+}
+
+func (e *binaryEncoder) rule(rule *ast.Rule) {
+	e.location(rule.Location)
+	e.flag(rule.Default)
+
+	head := rule.Head
+	e.location(head.Location)
+	e.str(string(head.Name))
+	e.terms(head.Args)
+	e.term(head.Key)
+	e.term(head.Value)
+	e.flag(head.Assign)
+
+	e.body(rule.Body)
+
+	e.flag(rule.Else != nil)
+	if rule.Else != nil {
+		e.rule(rule.Else)
+	}
+}
+
+func (e *binaryEncoder) body(body ast.Body) {
+	e.uvarint(uint64(len(body)))
+	for _, expr := range body {
+		e.expr(expr)
+	}
+}
+
+func (e *binaryEncoder) expr(expr *ast.Expr) {
+	e.location(expr.Location)
+	e.uvarint(uint64(expr.Index))
+	e.flag(expr.Negated)
+	e.flag(expr.Generated)
+
+	switch terms := expr.Terms.(type) {
+	case *ast.Term:
+		e.buf.WriteByte(exprTerm)
+		e.term(terms)
+	case []*ast.Term:
+		e.buf.WriteByte(exprCall)
+		e.terms(terms)
+	case *ast.SomeDecl:
+		e.buf.WriteByte(exprSome)
+		e.location(terms.Location)
+		e.terms(terms.Symbols)
+	default:
+		e.fail(fmt.Errorf("unsupported expression %v", expr))
+	}
+
+	e.uvarint(uint64(len(expr.With)))
+	for _, w := range expr.With {
+		e.location(w.Location)
+		e.term(w.Target)
+		e.term(w.Value)
+	}
+}
+
+func (e *binaryEncoder) terms(terms []*ast.Term) {
+	e.uvarint(uint64(len(terms)))
+	for _, term := range terms {
+		e.term(term)
+	}
+}
+
+func (e *binaryEncoder) term(term *ast.Term) {
+	if term == nil {
+		e.buf.WriteByte(tagNilTerm)
+		return
+	}
+
+	switch v := term.Value.(type) {
+	case ast.Null:
+		e.buf.WriteByte(tagNull)
+	case ast.Boolean:
+		if v {
+			e.buf.WriteByte(tagTrue)
+		} else {
+			e.buf.WriteByte(tagFalse)
+		}
+	case ast.Number:
+		s := string(v)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(i, 10) == s {
+			e.buf.WriteByte(tagInt)
+			e.varint(i)
+		} else {
+			e.buf.WriteByte(tagNumber)
+			e.str(s)
+		}
+	case ast.String:
+		e.buf.WriteByte(tagString)
+		e.str(string(v))
+	case ast.Var:
+		e.buf.WriteByte(tagVar)
+		e.str(string(v))
+	case ast.Ref:
+		e.buf.WriteByte(tagRef)
+		e.ref(v)
+	case ast.Array:
+		e.buf.WriteByte(tagArray)
+		e.terms(v)
+	case ast.Object:
+		e.buf.WriteByte(tagObject)
+		e.uvarint(uint64(v.Len()))
+		v.Foreach(func(key, value *ast.Term) {
+			e.term(key)
+			e.term(value)
+		})
+	case ast.Set:
+		e.buf.WriteByte(tagSet)
+		e.uvarint(uint64(v.Len()))
+		v.Foreach(func(elem *ast.Term) {
+			e.term(elem)
+		})
+	case *ast.ArrayComprehension:
+		e.buf.WriteByte(tagArrayComprehension)
+		e.term(v.Term)
+		e.body(v.Body)
+	case *ast.ObjectComprehension:
+		e.buf.WriteByte(tagObjectComprehension)
+		e.term(v.Key)
+		e.term(v.Value)
+		e.body(v.Body)
+	case *ast.SetComprehension:
+		e.buf.WriteByte(tagSetComprehension)
+		e.term(v.Term)
+		e.body(v.Body)
+	case ast.Call:
+		e.buf.WriteByte(tagCall)
+		e.terms(v)
+	default:
+		e.fail(fmt.Errorf("unsupported term %v", term))
+		return
+	}
+	e.location(term.Location)
+}
+
+func (e *binaryEncoder) ref(ref ast.Ref) {
+	e.terms(ref)
+}
+
+func (e *binaryEncoder) location(loc *ast.Location) {
+	if !e.locations {
+		return
+	}
+	e.flag(loc != nil)
+	if loc == nil {
+		return
+	}
+	e.str(loc.File)
+	e.uvarint(uint64(loc.Row))
+	e.uvarint(uint64(loc.Col))
+	e.str(string(loc.Text))
+}
+
+func (e *binaryEncoder) str(s string) {
+	i, ok := e.index[s]
+	if !ok {
+		i = uint64(len(e.table))
+		e.index[s] = i
+		e.table = append(e.table, s)
+	}
+	e.uvarint(i)
+}
+
+func (e *binaryEncoder) flag(b bool) {
+	if b {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *binaryEncoder) uvarint(u uint64) {
+	writeUvarint(e.buf, u)
+}
+
+func (e *binaryEncoder) varint(i int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, i)
+	e.buf.Write(buf[:n])
+}
+
+func (e *binaryEncoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// binaryDecoder reads the body of a binary module. Once a read fails every subsequent read returns a zero value and
+// the first error is kept in err, together with the position in r at which it occurred.
+type binaryDecoder struct {
+	r         *bytes.Reader
+	table     []string
+	locations bool
+	err       error
+	pos       int
+}
+
+func (d *binaryDecoder) module() *ast.Module {
+	module := &ast.Module{Package: &ast.Package{}}
+	module.Package.Location = d.location()
+	module.Package.Path = d.ref()
+
+	for i, n := uint64(0), d.count(); i < n; i++ {
+		imp := &ast.Import{Location: d.location()}
+		imp.Path = d.term()
+		imp.Alias = ast.Var(d.str())
+		module.Imports = append(module.Imports, imp)
+	}
+
+	for i, n := uint64(0), d.count(); i < n; i++ {
+		module.Rules = append(module.Rules, d.rule())
+	}
+
+	for i, n := uint64(0), d.count(); i < n; i++ {
+		c := &ast.Comment{Location: d.location()}
+		c.Text = []byte(d.str())
+		module.Comments = append(module.Comments, c)
+	}
+	return module
+}
+
+func (d *binaryDecoder) rule() *ast.Rule {
+	rule := &ast.Rule{Location: d.location()}
+	rule.Default = d.flag()
+
+	head := &ast.Head{Location: d.location()}
+	head.Name = ast.Var(d.str())
+	if args := d.terms(); len(args) > 0 {
+		head.Args = ast.Args(args)
+	}
+	head.Key = d.term()
+	head.Value = d.term()
+	head.Assign = d.flag()
+	rule.Head = head
+
+	rule.Body = d.body()
+
+	if d.flag() {
+		rule.Else = d.rule()
+	}
+	return rule
+}
+
+func (d *binaryDecoder) body() ast.Body {
+	n := d.count()
+	body := make(ast.Body, 0, n)
+	for i := uint64(0); i < n; i++ {
+		body = append(body, d.expr())
+	}
+	return body
+}
+
+func (d *binaryDecoder) expr() *ast.Expr {
+	expr := &ast.Expr{Location: d.location()}
+	expr.Index = int(d.uvarint())
+	expr.Negated = d.flag()
+	expr.Generated = d.flag()
+
+	switch kind := d.next(); kind {
+	case exprTerm:
+		expr.Terms = d.term()
+	case exprCall:
+		expr.Terms = d.terms()
+	case exprSome:
+		decl := &ast.SomeDecl{Location: d.location()}
+		decl.Symbols = d.terms()
+		expr.Terms = decl
+	default:
+		d.fail(fmt.Errorf("unknown expression kind %v", kind))
+	}
+
+	for i, n := uint64(0), d.count(); i < n; i++ {
+		w := &ast.With{Location: d.location()}
+		w.Target = d.term()
+		w.Value = d.term()
+		expr.With = append(expr.With, w)
+	}
+	return expr
+}
+
+func (d *binaryDecoder) terms() []*ast.Term {
+	n := d.count()
+	terms := make([]*ast.Term, 0, n)
+	for i := uint64(0); i < n; i++ {
+		terms = append(terms, d.term())
+	}
+	return terms
+}
+
+func (d *binaryDecoder) term() *ast.Term {
+	var value ast.Value
+	switch tag := d.next(); tag {
+	case tagNilTerm:
+		return nil
+	case tagNull:
+		value = ast.Null{}
+	case tagTrue:
+		value = ast.Boolean(true)
+	case tagFalse:
+		value = ast.Boolean(false)
+	case tagInt:
+		value = ast.Number(json.Number(strconv.FormatInt(d.varint(), 10)))
+	case tagNumber:
+		value = ast.Number(json.Number(d.str()))
+	case tagString:
+		value = ast.String(d.str())
+	case tagVar:
+		value = ast.Var(d.str())
+	case tagRef:
+		value = d.ref()
+	case tagArray:
+		value = ast.Array(d.terms())
+	case tagObject:
+		n := d.count()
+		pairs := make([][2]*ast.Term, 0, n)
+		for i := uint64(0); i < n; i++ {
+			key := d.term()
+			pairs = append(pairs, [2]*ast.Term{key, d.term()})
+			if d.err == nil && (pairs[i][0] == nil || pairs[i][1] == nil) {
+				d.fail(fmt.Errorf("object with a missing key or value"))
+			}
+		}
+		// OPA panics when hashing a missing key, so nothing is built once decoding has failed
+		if d.err != nil {
+			return nil
+		}
+		value = ast.NewObject(pairs...)
+	case tagSet:
+		elems := d.terms()
+		for _, elem := range elems {
+			if d.err == nil && elem == nil {
+				d.fail(fmt.Errorf("set with a missing element"))
+			}
+		}
+		if d.err != nil {
+			return nil
+		}
+		value = ast.NewSet(elems...)
+	case tagArrayComprehension:
+		compr := &ast.ArrayComprehension{Term: d.term()}
+		compr.Body = d.body()
+		value = compr
+	case tagObjectComprehension:
+		compr := &ast.ObjectComprehension{Key: d.term()}
+		compr.Value = d.term()
+		compr.Body = d.body()
+		value = compr
+	case tagSetComprehension:
+		compr := &ast.SetComprehension{Term: d.term()}
+		compr.Body = d.body()
+		value = compr
+	case tagCall:
+		value = ast.Call(d.terms())
+	default:
+		d.fail(fmt.Errorf("unknown term tag %v", tag))
+		return nil
+	}
+	return &ast.Term{Value: value, Location: d.location()}
+}
+
+func (d *binaryDecoder) ref() ast.Ref {
+	return ast.Ref(d.terms())
+}
+
+func (d *binaryDecoder) location() *ast.Location {
+	if !d.locations || !d.flag() {
+		return nil
+	}
+	loc := &ast.Location{File: d.str()}
+	loc.Row = int(d.uvarint())
+	loc.Col = int(d.uvarint())
+	loc.Text = []byte(d.str())
+	return loc
+}
+
+func (d *binaryDecoder) str() string {
+	i := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if i >= uint64(len(d.table)) {
+		d.fail(fmt.Errorf("string index %v out of range", i))
+		return ""
+	}
+	return d.table[i]
+}
+
+func (d *binaryDecoder) flag() bool {
+	return d.next() == 1
+}
+
+func (d *binaryDecoder) next() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	d.fail(err)
+	return b
+}
+
+// count reads the length of a list. Every element takes at least one byte, so lengths larger than the remaining
+// input are rejected before anything is allocated for them.
+func (d *binaryDecoder) count() uint64 {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(d.r.Len()) {
+		d.fail(fmt.Errorf("length %v exceeds remaining %v bytes", n, d.r.Len()))
+		return 0
+	}
+	return n
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	u, err := binary.ReadUvarint(d.r)
+	d.fail(err)
+	return u
+}
+
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	i, err := binary.ReadVarint(d.r)
+	d.fail(err)
+	return i
+}
+
+func (d *binaryDecoder) fail(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if d.err == nil && err != nil {
+		d.err = err
+		d.pos = int(d.r.Size()) - d.r.Len()
+	}
+}
+
+func writeUvarint(buf *bytes.Buffer, u uint64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(tmp, u)
+	buf.Write(tmp[:n])
+}
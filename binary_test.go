@@ -0,0 +1,194 @@
+package rego
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+const binaryPolicy = `
+	package test
+	import data.otherpackage
+
+	v1 = input.arg
+	v2 = "hello"
+	v3 = 123.1324
+	v4 = [1, 2, -3]
+	v5 = [e | e = v4[_]]
+	v6 = plus(6, 4)
+	v7 = {"a": 1, "b": [null, true]}
+	v8 = {1, 2, 3}
+	v9 = {k: v | v = v7[k]}
+	v10 = {x | x = v8[_]}
+	# comment
+
+	default boolrule = false
+	boolrule {
+		obj := v4
+		plus(6,5) == 11
+		not input.deny
+		count(v4) > 1 with input as {}
+	} else = false {
+		v2
+	}
+
+	setrule[result] {
+		some i
+		result = v5[i]
+	}
+
+	f(x) = y { y := x * 2 }
+	`
+
+func TestModuleSerializationBinary(t *testing.T) {
+	for _, withLocations := range []bool{false, true} {
+		mod := mustParse(t, "test.rego", binaryPolicy)
+		data, err := SerializeModuleBinary(mod, withLocations)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		result, err := DeserializeModuleBinary(data)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !result.Equal(mod) {
+			t.Fatalf("different modules produced:\n%v\n%v", mod, result)
+		}
+		if result.Rules[0].Module != result {
+			t.Fatalf("expected rules to reference their module")
+		}
+		if withLocations && (result.Rules[0].Location == nil || result.Rules[0].Location.File != "test.rego") {
+			t.Fatalf("expected locations to be restored")
+		}
+		if !withLocations && result.Rules[0].Location != nil {
+			t.Fatalf("expected locations to be dropped")
+		}
+		if err := Compile(NewCompiler(), map[string]*ast.Module{"test": result}); err != nil {
+			t.Fatalf("uncompilable: " + err.Error())
+		}
+	}
+}
+
+func TestModuleDeserializationBinaryTruncated(t *testing.T) {
+	data, err := SerializeModuleBinary(mustParse(t, "test.rego", binaryPolicy), true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for n := 0; n < len(data); n++ {
+		if _, err := DeserializeModuleBinary(data[:n]); err == nil {
+			t.Fatalf("did not catch truncation at %v bytes", n)
+		}
+	}
+}
+
+func TestModuleDeserializationBinaryTruncatedCollections(t *testing.T) {
+	mod := mustParse(t, "test.rego", "package p\nx = {\"a\": {1, 2}, \"b\": [1]}\ny = {1,2,3}")
+	for _, withLocations := range []bool{true, false} {
+		data, err := SerializeModuleBinary(mod, withLocations)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		// the header and string table take the first bytes, so the body is cut short from the middle on
+		for n := 0; n < len(data); n++ {
+			_, err := DeserializeModuleBinary(data[:n])
+			if err == nil {
+				t.Fatalf("did not catch truncation at %v bytes", n)
+			}
+			if n >= len(data)/2 && !IsDecodeErr(err) {
+				t.Fatalf("expected decode error at %v bytes, got %v", n, err)
+			}
+		}
+	}
+}
+
+func TestModuleSerializationBinaryNoPackage(t *testing.T) {
+	if _, err := SerializeModuleBinary(&ast.Module{}, false); err == nil {
+		t.Fatalf("did not catch module without a package")
+	}
+}
+
+// benchmarkPolicy builds a policy with n rules of the kind found in tenant policies
+func benchmarkPolicy(n int) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("package bench\n\ndefault allow = false\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(buf, "limit_%d = %d\n", i, i*10)
+		fmt.Fprintf(buf, "allow { input.user.roles[_] = data.roles[input.tenant].role_%d }\n", i)
+	}
+	return buf.String()
+}
+
+func benchmarkSerialization(b *testing.B, serialize func(*ast.Module) ([]byte, error)) {
+	mod, err := ParseBytes("bench.rego", []byte(benchmarkPolicy(100)))
+	if err != nil {
+		b.Fatalf(err.Error())
+	}
+	var data []byte
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if data, err = serialize(mod); err != nil {
+			b.Fatalf(err.Error())
+		}
+	}
+	b.ReportMetric(float64(len(data)), "bytes")
+}
+
+func BenchmarkSerializeJson(b *testing.B) {
+	benchmarkSerialization(b, SerializeModuleJson)
+}
+
+func BenchmarkSerializeGob(b *testing.B) {
+	benchmarkSerialization(b, SerializeModuleGob)
+}
+
+func BenchmarkSerializeBinary(b *testing.B) {
+	benchmarkSerialization(b, func(mod *ast.Module) ([]byte, error) {
+		return SerializeModuleBinary(mod, false)
+	})
+}
+
+func BenchmarkSerializeBinaryLocations(b *testing.B) {
+	benchmarkSerialization(b, func(mod *ast.Module) ([]byte, error) {
+		return SerializeModuleBinary(mod, true)
+	})
+}
+
+func benchmarkDeserialization(b *testing.B, serialize func(*ast.Module) ([]byte, error),
+	deserialize func([]byte) (*ast.Module, error)) {
+	mod, err := ParseBytes("bench.rego", []byte(benchmarkPolicy(100)))
+	if err != nil {
+		b.Fatalf(err.Error())
+	}
+	data, err := serialize(mod)
+	if err != nil {
+		b.Fatalf(err.Error())
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := deserialize(data); err != nil {
+			b.Fatalf(err.Error())
+		}
+	}
+}
+
+func BenchmarkDeserializeJson(b *testing.B) {
+	benchmarkDeserialization(b, SerializeModuleJson, DeserializeModuleJson)
+}
+
+func BenchmarkDeserializeGob(b *testing.B) {
+	benchmarkDeserialization(b, SerializeModuleGob, DeserializeModuleGob)
+}
+
+func BenchmarkDeserializeBinary(b *testing.B) {
+	benchmarkDeserialization(b, func(mod *ast.Module) ([]byte, error) {
+		return SerializeModuleBinary(mod, false)
+	}, DeserializeModuleBinary)
+}
+
+func BenchmarkDeserializeBinaryLocations(b *testing.B) {
+	benchmarkDeserialization(b, func(mod *ast.Module) ([]byte, error) {
+		return SerializeModuleBinary(mod, true)
+	}, DeserializeModuleBinary)
+}
@@ -0,0 +1,271 @@
+package rego
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+const manifestFile = ".manifest"
+
+// Manifest is the .manifest document of a bundle
+type Manifest struct {
+	Revision string `json:"revision"`
+	// Roots are the paths of data, slash separated and relative to data, that the bundle owns. Every data document
+	// and package in the bundle must fall under one of them. No roots means the bundle owns all of data.
+	Roots []string `json:"roots,omitempty"`
+}
+
+// ModuleFile is a policy stored in a bundle
+type ModuleFile struct {
+	// Path is the location of the file within the bundle
+	Path string
+	// Raw is the Rego source of the module. If it is empty when the bundle is written, Parsed is formatted instead.
+	Raw    []byte
+	Parsed *ast.Module
+}
+
+// Bundle is an OPA bundle: a gzipped tarball of policies, data documents and a manifest
+type Bundle struct {
+	Manifest Manifest
+	Data     map[string]interface{}
+	Modules  []ModuleFile
+}
+
+// ReadBundleFile reads the bundle stored at fpath. See ReadBundle.
+func ReadBundleFile(fpath string) (*Bundle, error) {
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadBundle(file)
+}
+
+// ReadBundle reads a bundle tarball from r. Policies are parsed, data documents, whether data.json, data.yaml or
+// data.yml, are mounted under the path implied by their directory and everything is checked against the roots
+// declared in the manifest. The whole tarball is read before giving up, so the returned *Errors lists every policy
+// that does not parse and every data document that does not decode or conflicts with another, not just the first.
+// Like Decompress, ReadBundle rejects a bundle holding a file larger than DefaultMaxDecompressedSize bytes.
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	return ReadBundleLimit(r, DefaultMaxDecompressedSize)
+}
+
+// ReadBundleLimit is like ReadBundle but rejects a bundle holding a file larger than maxFileSize bytes.
+func ReadBundleLimit(r io.Reader, maxFileSize int64) (*Bundle, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	errs := new(Errors)
+	b := &Bundle{Data: make(map[string]interface{})}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		fpath := "/" + strings.TrimLeft(path.Clean("/"+header.Name), "/")
+		raw, err := readLimited(tr, maxFileSize, fpath)
+		if err != nil {
+			return nil, err
+		}
+
+		switch name := path.Base(fpath); {
+		case fpath == "/"+manifestFile:
+			if err := json.Unmarshal(raw, &b.Manifest); err != nil {
+				errs.Add(fmt.Errorf("%v: %w", fpath, err))
+			}
+		case strings.HasSuffix(name, ".rego"):
+			module, err := ParseBytes(fpath, raw)
+			errs.Add(err)
+			b.Modules = append(b.Modules, ModuleFile{Path: fpath, Raw: raw, Parsed: module})
+		case dataFiles[name]:
+			value, err := decodeDataFile(fpath, raw)
+			if err != nil {
+				errs.Add(err)
+				continue
+			}
+			errs.Add(mergeData(b.Data, dataPath(path.Dir(fpath)), value, fpath))
+		}
+	}
+
+	if err := errs.NilIfEmpty(); err != nil {
+		return nil, err
+	}
+	if err := b.checkRoots(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WriteBundleFile writes b to fpath. See WriteBundle.
+func WriteBundleFile(fpath string, b *Bundle) error {
+	file, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	if err := WriteBundle(file, b); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WriteBundle writes b to w as a gzipped tarball containing the manifest, the data document as /data.json and every
+// module at its path. Modules without Raw source are formatted with SerializeModuleRego, which also handles modules
+// deserialized without locations.
+func WriteBundle(w io.Writer, b *Bundle) error {
+	if err := b.checkRoots(); err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifest, err := json.Marshal(b.Manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "/"+manifestFile, manifest); err != nil {
+		return err
+	}
+
+	data := b.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "/data.json", raw); err != nil {
+		return err
+	}
+
+	modules := append([]ModuleFile{}, b.Modules...)
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+	for _, mf := range modules {
+		raw := mf.Raw
+		if len(raw) == 0 {
+			if raw, err = SerializeModuleRego(mf.Parsed); err != nil {
+				return fmt.Errorf("%v: %w", mf.Path, err)
+			}
+		}
+		if err := writeTarFile(tw, mf.Path, raw); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// ParsedModules returns the modules in the bundle keyed by their path, ready to be passed to Compile.
+func (b *Bundle) ParsedModules() map[string]*ast.Module {
+	modules := make(map[string]*ast.Module, len(b.Modules))
+	for _, mf := range b.Modules {
+		modules[mf.Path] = mf.Parsed
+	}
+	return modules
+}
+
+// Store returns an in-memory store holding the bundle's data.
+func (b *Bundle) Store() storage.Store {
+	return inmem.NewFromObject(b.Data)
+}
+
+// checkRoots ensures that every data document and package in the bundle falls under one of the manifest's roots.
+func (b *Bundle) checkRoots() error {
+	if len(b.Manifest.Roots) == 0 {
+		return nil
+	}
+
+	errs := new(Errors)
+	b.checkDataRoots("", b.Data, errs)
+	for _, mf := range b.Modules {
+		if mf.Parsed == nil {
+			continue
+		}
+		pkg := strings.TrimPrefix(mf.Parsed.Package.Path.String(), "data.")
+		if !b.owns(strings.Replace(pkg, ".", "/", -1)) {
+			errs.Add(fmt.Errorf("%v: package %v is outside of the bundle roots", mf.Path, mf.Parsed.Package.Path))
+		}
+	}
+	return errs.NilIfEmpty()
+}
+
+// checkDataRoots adds an error to errs for every document in data, found at the slash separated path prefix, that is
+// not owned by the bundle. Objects that lie above a root are descended into.
+func (b *Bundle) checkDataRoots(prefix string, data map[string]interface{}, errs *Errors) {
+	for key, value := range data {
+		p := key
+		if prefix != "" {
+			p = prefix + "/" + key
+		}
+		if b.owns(p) {
+			continue
+		}
+		if obj, ok := value.(map[string]interface{}); ok && b.above(p) {
+			b.checkDataRoots(p, obj, errs)
+			continue
+		}
+		errs.Add(fmt.Errorf("data.%v is outside of the bundle roots", strings.Replace(p, "/", ".", -1)))
+	}
+}
+
+// above returns true if the slash separated path p is a proper prefix of one of the manifest's roots.
+func (b *Bundle) above(p string) bool {
+	for _, root := range b.Manifest.Roots {
+		if strings.HasPrefix(strings.Trim(root, "/"), p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// owns returns true if the slash separated path p falls under one of the manifest's roots.
+func (b *Bundle) owns(p string) bool {
+	for _, root := range b.Manifest.Roots {
+		root = strings.Trim(root, "/")
+		if root == "" || p == root || strings.HasPrefix(p, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func writeTarFile(tw *tar.Writer, fpath string, data []byte) error {
+	header := &tar.Header{
+		Name:     fpath,
+		Mode:     0644,
+		Typeflag: tar.TypeReg,
+		Size:     int64(len(data)),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,171 @@
+package rego
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	mod := mustParse(t, "/example/policy.rego", `
+	package example
+	rule = x { x := input.a + data.example.b }
+	`)
+
+	b := &Bundle{
+		Manifest: Manifest{Revision: "abc123", Roots: []string{"example"}},
+		Data:     map[string]interface{}{"example": map[string]interface{}{"b": 2}},
+		Modules: []ModuleFile{
+			{Path: "/example/policy.rego", Parsed: mod},
+			{Path: "/example/raw.rego", Raw: []byte("package example\n\nother = 5\n")},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteBundle(buf, b); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	result, err := ReadBundle(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result.Manifest.Revision != "abc123" {
+		t.Fatalf("expected revision abc123, got %v", result.Manifest.Revision)
+	}
+	if len(result.Modules) != 2 {
+		t.Fatalf("expected 2 modules, got %v", len(result.Modules))
+	}
+
+	cmp := NewCompiler()
+	if err := Compile(cmp, result.ParsedModules()); err != nil {
+		t.Fatalf(err.Error())
+	}
+	store := result.Store()
+	res, err := QueryRule(cmp, "example", "rule", map[string]interface{}{"a": 1}, &store)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 3)
+}
+
+func TestBundleRoots(t *testing.T) {
+	b := &Bundle{
+		Manifest: Manifest{Roots: []string{"a/b"}},
+		Data: map[string]interface{}{
+			"a": map[string]interface{}{
+				"b": map[string]interface{}{"x": 1},
+				"c": 2,
+			},
+		},
+		Modules: []ModuleFile{
+			{Path: "/ok.rego", Parsed: mustParse(t, "/ok.rego", "package a.b.c\nx = 1")},
+			{Path: "/bad.rego", Parsed: mustParse(t, "/bad.rego", "package z\nx = 1")},
+		},
+	}
+
+	err := WriteBundle(new(bytes.Buffer), b)
+	errs, ok := err.(*Errors)
+	if !ok {
+		t.Fatalf("expected *Errors, got %v", err)
+	}
+	if len(*errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+}
+
+func TestBundleWithoutLocations(t *testing.T) {
+	mod := mustParse(t, "/example/policy.rego", `
+	package example
+	default allow = false
+	allow { input.user == "admin"; not input.deny }
+	level = "high" { input.score > 5 } else = "low" { true }
+	owners[o] { some i; o := input.resources[i].owner }
+	`)
+	data, err := SerializeModuleBinary(mod, false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	deserialized, err := DeserializeModuleBinary(data)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	b := &Bundle{Modules: []ModuleFile{{Path: "/example/policy.rego", Parsed: deserialized}}}
+	buf := new(bytes.Buffer)
+	if err := WriteBundle(buf, b); err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := ReadBundle(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !ModulesEqual(result.Modules[0].Parsed, mod) {
+		t.Fatalf("different modules produced:\n%v\n%v", mod, result.Modules[0].Parsed)
+	}
+
+	expected, err := SerializeModuleRego(mod)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(result.Modules[0].Raw) != string(expected) {
+		t.Fatalf("expected the module to be formatted as the original:\n%s\n%s", expected, result.Modules[0].Raw)
+	}
+}
+
+func TestReadBundleYaml(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	files := map[string]string{
+		"/data.json":          `{"top": true}`,
+		"/example/data.yaml":  "limits:\n  max: 10",
+		"/example/b/data.yml": "- 1\n- 2",
+	}
+	for name, content := range files {
+		if err := writeTarFile(tw, name, []byte(content)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	result, err := ReadBundle(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := map[string]interface{}{
+		"top": true,
+		"example": map[string]interface{}{
+			"limits": map[string]interface{}{"max": 10},
+			"b":      []int{1, 2},
+		},
+	}
+	validate(t, result.Data, expected)
+}
+
+func TestReadBundleLimit(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	if err := writeTarFile(tw, "/example/policy.rego", make([]byte, 1025)); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, err := ReadBundleLimit(buf, 1024)
+	if err == nil || !strings.Contains(err.Error(), "/example/policy.rego exceeds 1024 bytes") {
+		t.Fatalf("did not catch file exceeding the limit, got %v", err)
+	}
+}
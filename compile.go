@@ -1,17 +1,78 @@
 package rego
 
-import "github.com/open-policy-agent/opa/ast"
+import (
+	"errors"
+	"sort"
+	"github.com/open-policy-agent/opa/ast"
+)
 
 // Creates a new Compiler
 func NewCompiler() (*ast.Compiler) {
 	return ast.NewCompiler()
 }
 
-// Compile the modules with the specified compiler
+// Compile the modules with the specified compiler. Any queries prepared against cmp are invalidated. Compilation
+// errors are returned as *Errors holding a *CompileErr for each error. The ast.Errors reported by the OPA compiler
+// can still be retrieved with errors.As.
 func Compile(cmp *ast.Compiler, modules map[string]*ast.Module) (error) {
+	InvalidatePrepared(cmp)
 	cmp.Compile(modules)
 	if cmp.Failed() {
-		return cmp.Errors
+		return newCompileErrors(cmp.Errors, modules)
 	}
 	return nil
 }
+
+// IsCompileErr returns true if the given error is or wraps a CompileErr, including through *Errors
+func IsCompileErr(err error) bool {
+	var e *CompileErr
+	return errors.As(err, &e)
+}
+
+// newCompileErrors converts the errors of a compiler into *Errors holding a *CompileErr for each. modules are used
+// to find the rule each error was found in.
+func newCompileErrors(astErrs ast.Errors, modules map[string]*ast.Module) error {
+	errs := new(Errors)
+	for _, e := range astErrs {
+		errs.Add(&CompileErr{
+			Code:     e.Code,
+			Message:  e.Message,
+			Location: newLocation(e.Location),
+			Details:  errorDetails(e),
+			Rule:     ruleAt(modules, e.Location),
+			Cause:    e,
+		})
+	}
+	return errs.NilIfEmpty()
+}
+
+// ruleAt returns the name of the rule in modules that loc falls in: the last rule in the same file that starts at
+// or before loc. If modules under several keys hold rules from the same file and row, the one whose key sorts last
+// wins.
+func ruleAt(modules map[string]*ast.Module, loc *ast.Location) string {
+	if loc == nil {
+		return ""
+	}
+	keys := make([]string, 0, len(modules))
+	for key := range modules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var name string
+	row := 0
+	for _, key := range keys {
+		module := modules[key]
+		if module == nil {
+			continue
+		}
+		for _, rule := range module.Rules {
+			start := rule.Location
+			if start == nil || start.File != loc.File || start.Row > loc.Row || start.Row < row {
+				continue
+			}
+			name, row = string(rule.Head.Name), start.Row
+		}
+	}
+	return name
+}
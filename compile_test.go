@@ -0,0 +1,80 @@
+package rego
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestCompileErr(t *testing.T) {
+	mod := mustParse(t, "policy.rego", `package test
+
+allow {
+	input.admin
+}
+
+broken = x {
+	input.y
+}
+`)
+	err := Compile(NewCompiler(), map[string]*ast.Module{"policy.rego": mod})
+	if !IsCompileErr(err) {
+		t.Fatalf("expected compile error, got %v", err)
+	}
+	ce := (*err.(*Errors))[0].(*CompileErr)
+	if ce.Code != "rego_unsafe_var_error" {
+		t.Fatalf("expected rego_unsafe_var_error, got %v", ce.Code)
+	}
+	if ce.Location == nil || ce.Location.File != "policy.rego" || ce.Location.Row != 7 {
+		t.Fatalf("unexpected location %v", ce.Location)
+	}
+	if ce.Rule != "broken" {
+		t.Fatalf("expected error in rule broken, got %v", ce.Rule)
+	}
+	if IsParseErr(err) || IsEvalErr(err) {
+		t.Fatalf("compile error misclassified")
+	}
+}
+
+func TestCompileErrASTErrors(t *testing.T) {
+	mod := mustParse(t, "policy.rego", "package test\n\nbroken = x {\n\tinput.y\n}\n")
+	err := Compile(NewCompiler(), map[string]*ast.Module{"policy.rego": mod})
+	var astErrs ast.Errors
+	if !errors.As(err, &astErrs) {
+		t.Fatalf("expected ast.Errors to be reachable, got %v", err)
+	}
+	if len(astErrs) != 1 || astErrs[0].Code != ast.UnsafeVarErr {
+		t.Fatalf("unexpected OPA errors %v", astErrs)
+	}
+}
+
+func TestCompileErrDetails(t *testing.T) {
+	mod := mustParse(t, "policy.rego", "package test\n\nbroken {\n\tplus(\"a\", 1, x)\n}\n")
+	err := Compile(NewCompiler(), map[string]*ast.Module{"policy.rego": mod})
+	if !IsCompileErr(err) {
+		t.Fatalf("expected compile error, got %v", err)
+	}
+	ce := (*err.(*Errors))[0].(*CompileErr)
+	if len(ce.Details) == 0 {
+		t.Fatalf("expected details of %v", ce.Code)
+	}
+	var astErrs ast.Errors
+	if !errors.As(err, &astErrs) || ce.Error() != astErrs[0].Error() {
+		t.Fatalf("expected error to be formatted as the OPA error:\n%v\n%v", ce, astErrs)
+	}
+}
+
+func TestCompileErrRuleTie(t *testing.T) {
+	// the same file parsed under two keys, so both rules start on the row of the error
+	modules := map[string]*ast.Module{
+		"a": mustParse(t, "policy.rego", "package a\n\nfirst = x {\n\tinput.y\n}\n"),
+		"b": mustParse(t, "policy.rego", "package b\n\nsecond = x {\n\tinput.y\n}\n"),
+	}
+	for i := 0; i < 20; i++ {
+		loc := &ast.Location{File: "policy.rego", Row: 4}
+		if name := ruleAt(modules, loc); name != "second" {
+			t.Fatalf("expected the rule of the last module, got %v", name)
+		}
+	}
+}
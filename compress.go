@@ -0,0 +1,141 @@
+package rego
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Compression identifies a compression algorithm applied to a serialized module
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("compression(%d)", byte(c))
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// SerializeModuleCompressed serializes module in the given format, wrapped in an envelope as by
+// SerializeModuleEnvelope, and compresses the result. The output can be read by DeserializeModuleEnvelope or by the
+// deserializer of the format, which detect the compression automatically.
+func SerializeModuleCompressed(module *ast.Module, format Format, c Compression) ([]byte, error) {
+	data, err := SerializeModuleEnvelope(module, format)
+	if err != nil {
+		return nil, err
+	}
+	return Compress(data, c)
+}
+
+// Compress compresses data with c. The zstd encoder is pure Go.
+func Compress(data []byte, c Compression) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		buf := new(bytes.Buffer)
+		gw := gzip.NewWriter(buf)
+		if _, err := gw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer enc.Close()
+		return enc.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %v", c)
+	}
+}
+
+// DefaultMaxDecompressedSize is the largest size, in bytes, that Decompress inflates data to. Compressed data is tiny
+// compared to what it can expand to, so without a limit a few kilobytes of crafted input could exhaust memory.
+// Modules rarely exceed a few megabytes; use DecompressLimit if yours do.
+const DefaultMaxDecompressedSize int64 = 64 << 20
+
+// Decompress detects how data was compressed by Compress and decompresses it. Data that is not compressed is
+// returned as it is. Data that decompresses to more than DefaultMaxDecompressedSize bytes is rejected. The
+// deserializers decompress their input with Decompress.
+func Decompress(data []byte) ([]byte, error) {
+	return DecompressLimit(data, DefaultMaxDecompressedSize)
+}
+
+// DecompressLimit is like Decompress but rejects data that decompresses to more than max bytes. To read a module
+// larger than DefaultMaxDecompressedSize, decompress it with DecompressLimit before passing it to a deserializer,
+// which returns data that is not compressed as it is.
+func DecompressLimit(data []byte, max int64) ([]byte, error) {
+	var r io.Reader
+	switch DetectCompression(data) {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case CompressionZstd:
+		dec, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		r = dec
+	default:
+		return data, nil
+	}
+
+	return readLimited(r, max, "decompressed data")
+}
+
+// readLimited reads r to the end, failing if it holds more than max bytes. what names the data in the error.
+func readLimited(r io.Reader, max int64, what string) ([]byte, error) {
+	// one byte more than the limit is read so that data exactly at the limit is accepted
+	data, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("%v exceeds %v bytes", what, max)
+	}
+	return data, nil
+}
+
+// DetectCompression returns the compression applied to data, judging by its magic number.
+func DetectCompression(data []byte) Compression {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(data, zstdMagic):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
@@ -0,0 +1,78 @@
+package rego
+
+import (
+	"testing"
+)
+
+func TestModuleSerializationCompressed(t *testing.T) {
+	for _, format := range []Format{FormatJson, FormatGob} {
+		for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+			mod := mustParse(t, "test", envelopePolicy)
+			data, err := SerializeModuleCompressed(mod, format, c)
+			if err != nil {
+				t.Fatalf("%v/%v: %v", format, c, err)
+			}
+			if DetectCompression(data) != c {
+				t.Fatalf("%v/%v: detected %v", format, c, DetectCompression(data))
+			}
+
+			result, err := DeserializeModuleEnvelope(data)
+			if err != nil {
+				t.Fatalf("%v/%v: %v", format, c, err)
+			}
+			if len(result.Rules) != 2 {
+				t.Fatalf("%v/%v: expected 2 rules, got %v", format, c, len(result.Rules))
+			}
+		}
+	}
+}
+
+func TestDeserializeModuleJsonCompressed(t *testing.T) {
+	data, err := SerializeModuleJson(mustParse(t, "test", envelopePolicy))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		compressed, err := Compress(data, c)
+		if err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+		if _, err := DeserializeModuleJson(compressed); err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+	}
+}
+
+func TestDeserializeModuleBinaryCompressed(t *testing.T) {
+	data, err := SerializeModuleBinary(mustParse(t, "test", binaryPolicy), false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	compressed, err := Compress(data, CompressionZstd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := DeserializeModuleBinary(compressed); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		atLimit, err := Compress(make([]byte, 1024), c)
+		if err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+		if _, err := DecompressLimit(atLimit, 1024); err != nil {
+			t.Fatalf("%v: expected data at the limit to be accepted, got %v", c, err)
+		}
+
+		bomb, err := Compress(make([]byte, 1025), c)
+		if err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+		if _, err := DecompressLimit(bomb, 1024); err == nil {
+			t.Fatalf("%v: did not catch data exceeding the limit", c)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package rego
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+// dataFiles are the names of the files LoadData treats as data documents
+var dataFiles = map[string]bool{
+	"data.json": true,
+	"data.yaml": true,
+	"data.yml":  true,
+}
+
+// LoadStore loads the data documents under root as by LoadData and returns an in-memory store containing them.
+func LoadStore(root string) (storage.Store, error) {
+	data, err := LoadData(root)
+	if err != nil {
+		return nil, err
+	}
+	return inmem.NewFromObject(data), nil
+}
+
+// LoadData recursively reads every data.json, data.yaml and data.yml file under root and merges them into a single
+// document. As in OPA bundles, each file is mounted under the path implied by its directory, so the contents of
+// root/a/b/data.json end up under data.a.b. Two files that set the same path are reported as a conflict. Unreadable
+// files and conflicts do not stop the walk, so one call reports every broken file under root.
+//
+// YAML files are read as YAML 1.1, like OPA does, so unquoted keys such as y, no or on are booleans and end up as
+// the keys "true" and "false". Quote such keys to keep them. A file that conflicts with files read before it is not
+// merged at all.
+func LoadData(root string) (map[string]interface{}, error) {
+	errs := new(Errors)
+	data := make(map[string]interface{})
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !dataFiles[info.Name()] {
+			return nil
+		}
+
+		value, err := readDataFile(path)
+		if err != nil {
+			errs.Add(err)
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		errs.Add(mergeData(data, dataPath(filepath.ToSlash(rel)), value, path))
+		return nil
+	})
+	errs.Add(err)
+	return data, errs.NilIfEmpty()
+}
+
+// readDataFile reads a JSON or YAML document from fpath. Numbers are kept as json.Number, as OPA expects.
+func readDataFile(fpath string) (interface{}, error) {
+	raw, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDataFile(fpath, raw)
+}
+
+// decodeDataFile decodes raw, the contents of the data file fname, as JSON or YAML depending on the extension of fname
+func decodeDataFile(fname string, raw []byte) (interface{}, error) {
+	if filepath.Ext(fname) != ".json" {
+		var err error
+		raw, err = yaml.YAMLToJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", fname, err)
+		}
+	}
+	return decodeData(fname, raw)
+}
+
+// decodeData decodes a JSON document keeping numbers as json.Number. fname is used to write error messages.
+func decodeData(fname string, raw []byte) (interface{}, error) {
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("%v: %w", fname, err)
+	}
+	return value, nil
+}
+
+// dataPath splits a slash separated directory path into the keys it is mounted under. The root directory is mounted
+// at the top of the document.
+func dataPath(dir string) []string {
+	dir = strings.Trim(dir, "/")
+	if dir == "" || dir == "." {
+		return nil
+	}
+	return strings.Split(dir, "/")
+}
+
+// mergeData mounts value at path within data. fname is used to write error messages. data is only modified if value
+// does not conflict with it, so a conflicting file contributes nothing.
+func mergeData(data map[string]interface{}, path []string, value interface{}, fname string) error {
+	if len(path) == 0 {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("%v: root data document must be an object", fname)
+		}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		value = map[string]interface{}{path[i]: value}
+	}
+
+	src := value.(map[string]interface{})
+	if err := checkMerge(data, src, nil, fname); err != nil {
+		return err
+	}
+	mergeObjects(data, src)
+	return nil
+}
+
+// checkMerge fails if merging src into dst would replace a value of dst, i.e. if both define the same key and the
+// values are not both objects.
+func checkMerge(dst, src map[string]interface{}, path []string, fname string) error {
+	for key, value := range src {
+		keyPath := append(append([]string{}, path...), key)
+		existing, ok := dst[key]
+		if !ok {
+			continue
+		}
+		dstObj, ok1 := existing.(map[string]interface{})
+		srcObj, ok2 := value.(map[string]interface{})
+		if !ok1 || !ok2 {
+			return fmt.Errorf("%v: conflicting value at data.%v", fname, strings.Join(keyPath, "."))
+		}
+		if err := checkMerge(dstObj, srcObj, keyPath, fname); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeObjects merges src into dst. The caller must have checked with checkMerge that they do not conflict.
+func mergeObjects(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if dstObj, ok := dst[key].(map[string]interface{}); ok {
+			mergeObjects(dstObj, value.(map[string]interface{}))
+			continue
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,98 @@
+package rego
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadData(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"data.json":      `{"top": true}`,
+		"a/data.json":    `{"x": 1}`,
+		"a/b/data.yaml":  "list: [1, 2]\nz: hello",
+		"c/data.yml":     "- 1\n- 2",
+		"a/ignored.json": `{"ignored": true}`,
+	})
+	defer os.RemoveAll(root)
+
+	data, err := LoadData(root)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := map[string]interface{}{
+		"top": true,
+		"a": map[string]interface{}{
+			"x": 1,
+			"b": map[string]interface{}{"list": []int{1, 2}, "z": "hello"},
+		},
+		"c": []int{1, 2},
+	}
+	validate(t, data, expected)
+}
+
+func TestLoadDataConflict(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"data.json":   `{"a": {"x": 1}}`,
+		"a/data.json": `{"x": 2}`,
+	})
+	defer os.RemoveAll(root)
+
+	_, err := LoadData(root)
+	if err == nil {
+		t.Fatalf("did not catch conflict")
+	}
+	if !strings.Contains(err.Error(), "conflicting value at data.a.x") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadDataConflictUnmerged(t *testing.T) {
+	// a/data.json is read before data.json, whose b must not be merged since its a.x conflicts
+	root := writeFiles(t, map[string]string{
+		"a/data.json": `{"x": 2}`,
+		"data.json":   `{"a": {"x": 1}, "b": 3}`,
+	})
+	defer os.RemoveAll(root)
+
+	data, err := LoadData(root)
+	if err == nil {
+		t.Fatalf("did not catch conflict")
+	}
+	validate(t, data, map[string]interface{}{"a": map[string]interface{}{"x": 2}})
+}
+
+func TestLoadDataYamlBooleanKeys(t *testing.T) {
+	// YAML 1.1 reads these unquoted keys as booleans, as documented on LoadData
+	root := writeFiles(t, map[string]string{
+		"data.yaml": "y: 1\nno: 2\n\"on\": 3",
+	})
+	defer os.RemoveAll(root)
+
+	data, err := LoadData(root)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, data, map[string]interface{}{"true": 1, "false": 2, "on": 3})
+}
+
+func TestLoadStore(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"limits/data.json": `{"max": 10}`,
+	})
+	defer os.RemoveAll(root)
+
+	store, err := LoadStore(root)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cmp := setup(`
+	package test
+	eval = x { x := data.limits.max }
+	`)
+	res, err := QueryRule(cmp, "test", "eval", nil, &store)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 10)
+}
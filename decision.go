@@ -0,0 +1,85 @@
+package rego
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// Decision is the outcome of evaluating a boolean rule with Decide
+type Decision struct {
+	Allowed bool
+	// Defaulted is true if the rule was undefined and Allowed is the default that was given to Decide
+	Defaulted bool
+	// Reason describes how the decision was reached
+	Reason string
+}
+
+// NonBooleanErr represents error caused by a decision rule producing a value that is not a boolean
+type NonBooleanErr struct {
+	Message string
+	// Value is the value the rule produced
+	Value interface{}
+}
+
+// NewNonBooleanError creates a new NonBooleanErr for value, produced by rule
+func NewNonBooleanError(rule string, value interface{}) *NonBooleanErr {
+	return &NonBooleanErr{
+		Message: fmt.Sprintf("%v: expected boolean, got %v", rule, kindOf(value)),
+		Value:   value,
+	}
+}
+
+func (e *NonBooleanErr) Error() string {
+	return e.Message
+}
+
+// IsNonBooleanErr returns true if the given error is or wraps a NonBooleanErr
+func IsNonBooleanErr(err error) bool {
+	var e *NonBooleanErr
+	return errors.As(err, &e)
+}
+
+// Allow evaluates rule in pkg and returns true only if it is true. An undefined rule denies.
+func Allow(cmp *ast.Compiler, pkg, rule string, input interface{}, store *storage.Store) (bool, error) {
+	d, err := Decide(cmp, pkg, rule, input, store, false)
+	return d.Allowed, err
+}
+
+// Decide evaluates rule in pkg, which must produce a single boolean. If the rule is undefined, the decision is def
+// and is marked as defaulted. A NonBooleanErr is returned if the rule produces anything other than a boolean; any
+// other error, including the rule producing multiple results, is returned as it is. When an error is returned the
+// decision denies.
+func Decide(cmp *ast.Compiler, pkg, rule string, input interface{}, store *storage.Store, def bool) (Decision, error) {
+	return DecideContext(context.Background(), cmp, pkg, rule, input, store, def)
+}
+
+// DecideContext is like Decide but evaluates under ctx.
+func DecideContext(ctx context.Context, cmp *ast.Compiler, pkg, rule string, input interface{}, store *storage.Store, def bool) (Decision, error) {
+	name := ruleQuery(pkg, rule)
+
+	res, err := QueryRuleContext(ctx, cmp, pkg, rule, input, store)
+	if IsUndefined(err) {
+		return Decision{
+			Allowed:   def,
+			Defaulted: true,
+			Reason:    fmt.Sprintf("%v is undefined, defaulting to %v", name, def),
+		}, nil
+	}
+	if err != nil {
+		return Decision{Reason: err.Error()}, err
+	}
+
+	allowed, ok := res.(bool)
+	if !ok {
+		err := NewNonBooleanError(name, res)
+		return Decision{Reason: err.Error()}, err
+	}
+	return Decision{
+		Allowed: allowed,
+		Reason:  fmt.Sprintf("%v is %v", name, allowed),
+	}, nil
+}
@@ -0,0 +1,71 @@
+package rego
+
+import (
+	"testing"
+)
+
+const decisionPolicy = `
+	package test
+
+	allow { input.admin }
+	deny = false { input.admin }
+	level = 3
+	multi[x] { x := input.values[_] }
+`
+
+func TestAllow(t *testing.T) {
+	cmp := setup(decisionPolicy)
+
+	allowed, err := Allow(cmp, "test", "allow", map[string]interface{}{"admin": true}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !allowed {
+		t.Fatalf("expected allow")
+	}
+
+	allowed, err = Allow(cmp, "test", "allow", map[string]interface{}{"admin": false}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if allowed {
+		t.Fatalf("expected undefined rule to deny")
+	}
+}
+
+func TestDecide(t *testing.T) {
+	cmp := setup(decisionPolicy)
+
+	d, err := Decide(cmp, "test", "deny", map[string]interface{}{"admin": true}, nil, true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if d.Allowed || d.Defaulted || d.Reason == "" {
+		t.Fatalf("unexpected decision %+v", d)
+	}
+
+	d, err = Decide(cmp, "test", "allow", nil, nil, true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !d.Allowed || !d.Defaulted {
+		t.Fatalf("expected defaulted allow, got %+v", d)
+	}
+}
+
+func TestDecideNonBoolean(t *testing.T) {
+	cmp := setup(decisionPolicy)
+
+	d, err := Decide(cmp, "test", "level", nil, nil, true)
+	if !IsNonBooleanErr(err) {
+		t.Fatalf("expected NonBooleanErr, got %v", err)
+	}
+	if d.Allowed {
+		t.Fatalf("expected error to deny, got %+v", d)
+	}
+
+	_, err = Decide(cmp, "test", "multi", map[string]interface{}{"values": []interface{}{1, 2}}, nil, true)
+	if !IsNonBooleanErr(err) {
+		t.Fatalf("expected NonBooleanErr for set, got %v", err)
+	}
+}
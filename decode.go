@@ -0,0 +1,301 @@
+package rego
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// DecodeErr represents error generated while decoding the result of a query into a Go value
+type DecodeErr struct {
+	// Path is the position of the offending value in the result, e.g. data.pkg.rule.users[2].name, or in serialized
+	// data, e.g. byte 42
+	Path    string
+	Message string
+}
+
+// NewDecodeError creates a new DecodeErr for the value at path
+func NewDecodeError(path, msg string) *DecodeErr {
+	return &DecodeErr{Path: path, Message: msg}
+}
+
+func (e *DecodeErr) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// IsDecodeErr returns true if the given error is or wraps a DecodeErr
+func IsDecodeErr(err error) bool {
+	var e *DecodeErr
+	return errors.As(err, &e)
+}
+
+// QueryRuleInto is like QueryRule but decodes the single value produced into out, which must be a non-nil pointer.
+// Values are decoded like encoding/json would decode their JSON form: structs are filled by field name or json tag,
+// sets and arrays become slices or arrays, objects become maps or structs and numbers become any numeric type they
+// fit in. If a value cannot be decoded a DecodeErr naming its path is returned.
+func QueryRuleInto(cmp *ast.Compiler, pkg, rule string, input interface{}, store *storage.Store, out interface{}) error {
+	return QueryRuleIntoContext(context.Background(), cmp, pkg, rule, input, store, out)
+}
+
+// QueryRuleIntoContext is like QueryRuleInto but evaluates under ctx.
+func QueryRuleIntoContext(ctx context.Context, cmp *ast.Compiler, pkg, rule string, input interface{}, store *storage.Store, out interface{}) error {
+	res, err := QueryRuleContext(ctx, cmp, pkg, rule, input, store)
+	if err != nil {
+		return err
+	}
+	return decodeAt(ruleQuery(pkg, rule), res, out)
+}
+
+// Decode decodes value, as found in a rego.ResultSet, into out, which must be a non-nil pointer.
+func Decode(value interface{}, out interface{}) error {
+	return decodeAt("result", value, out)
+}
+
+func decodeAt(path string, value interface{}, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return NewDecodeError(path, fmt.Sprintf("cannot decode into non-pointer %T", out))
+	}
+	return decodeValue(path, value, rv.Elem())
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// decodeValue decodes value into the settable v. path is the position of value in the result.
+func decodeValue(path string, value interface{}, v reflect.Value) error {
+	if v.CanAddr() && v.Addr().Type().Implements(jsonUnmarshalerType) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return NewDecodeError(path, err.Error())
+		}
+		if err := v.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(data); err != nil {
+			return NewDecodeError(path, err.Error())
+		}
+		return nil
+	}
+
+	if value == nil {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		return NewDecodeError(path, fmt.Sprintf("cannot decode null into %v", v.Type()))
+	}
+
+	if s, ok := value.(string); ok && v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return NewDecodeError(path, err.Error())
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(path, value, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return NewDecodeError(path, fmt.Sprintf("cannot decode into non-empty interface %v", v.Type()))
+		}
+		v.Set(reflect.ValueOf(value))
+		return nil
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		v.SetString(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := number(value)
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil || v.OverflowInt(i) {
+			return NewDecodeError(path, fmt.Sprintf("number %v does not fit in %v", n, v.Type()))
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := number(value)
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		u, err := strconv.ParseUint(n, 10, 64)
+		if err != nil || v.OverflowUint(u) {
+			return NewDecodeError(path, fmt.Sprintf("number %v does not fit in %v", n, v.Type()))
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, ok := number(value)
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil || math.IsInf(f, 0) || v.OverflowFloat(f) {
+			return NewDecodeError(path, fmt.Sprintf("number %v does not fit in %v", n, v.Type()))
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.Slice:
+		elems, ok := value.([]interface{})
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		slice := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			if err := decodeValue(fmt.Sprintf("%v[%d]", path, i), elem, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Array:
+		elems, ok := value.([]interface{})
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		if len(elems) != v.Len() {
+			return NewDecodeError(path, fmt.Sprintf("cannot decode %d elements into %v", len(elems), v.Type()))
+		}
+		for i, elem := range elems {
+			if err := decodeValue(fmt.Sprintf("%v[%d]", path, i), elem, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return NewDecodeError(path, fmt.Sprintf("cannot decode object into %v: keys must be strings", v.Type()))
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(obj))
+		for key, elem := range obj {
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(path+"."+key, elem, ev); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), ev)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch(path, value, v.Type())
+		}
+		return decodeStruct(path, obj, v)
+	}
+	return NewDecodeError(path, fmt.Sprintf("cannot decode into %v", v.Type()))
+}
+
+// decodeStruct decodes obj into the struct v through encoding/json, so keys are matched to fields, including the
+// promoted fields of embedded structs, exactly as json.Unmarshal would. Keys without a matching field are ignored.
+func decodeStruct(path string, obj map[string]interface{}, v reflect.Value) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return NewDecodeError(path, err.Error())
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(ptr.Interface()); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) || typeErr.Field == "" {
+			return NewDecodeError(path, err.Error())
+		}
+		msg := err.Error()
+		if err == error(typeErr) {
+			msg = fmt.Sprintf("cannot decode %v into %v", typeErr.Value, typeErr.Type)
+		}
+		return NewDecodeError(fieldPath(path, obj, typeErr.Field), msg)
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+// fieldPath returns the path of the value that field, as reported by encoding/json for a value decoded from obj,
+// names: the dotted keys of field are followed through obj so that array elements are shown by index.
+func fieldPath(path string, obj map[string]interface{}, field string) string {
+	var value interface{} = obj
+	for _, key := range strings.Split(field, ".") {
+		switch v := value.(type) {
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return path + "." + key
+			}
+			path, value = fmt.Sprintf("%v[%d]", path, i), v[i]
+		case map[string]interface{}:
+			path, value = path+"."+key, v[key]
+		default:
+			path, value = path+"."+key, nil
+		}
+	}
+	return path
+}
+
+// number returns the textual form of value if it is a number
+func number(value interface{}) (string, bool) {
+	switch n := value.(type) {
+	case json.Number:
+		return n.String(), true
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(n), true
+	case int64:
+		return strconv.FormatInt(n, 10), true
+	}
+	return "", false
+}
+
+func mismatch(path string, value interface{}, t reflect.Type) error {
+	return NewDecodeError(path, fmt.Sprintf("cannot decode %v into %v", kindOf(value), t))
+}
+
+// kindOf names the JSON kind of value
+func kindOf(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number, float64, int, int64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
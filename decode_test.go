@@ -0,0 +1,178 @@
+package rego
+
+import (
+	"testing"
+)
+
+type decodeUser struct {
+	Name  string   `json:"name"`
+	Age   uint8    `json:"age"`
+	Roles []string `json:"roles"`
+	Admin bool
+	Score *float64 `json:"score"`
+}
+
+func TestQueryRuleInto(t *testing.T) {
+	cmp := setup(`
+	package test
+
+	users = [u | u := {"name": "alice", "age": 30, "roles": ["admin", "dev"], "admin": true, "score": 1.5}]
+	counts = {"a": 1, "b": 2}
+	`)
+
+	var users []decodeUser
+	if err := QueryRuleInto(cmp, "test", "users", nil, nil, &users); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(users) != 1 {
+		t.Fatalf("expected 1 user, got %v", len(users))
+	}
+	u := users[0]
+	if u.Name != "alice" || u.Age != 30 || len(u.Roles) != 2 || !u.Admin || u.Score == nil || *u.Score != 1.5 {
+		t.Fatalf("unexpected user %+v", u)
+	}
+
+	var counts map[string]int
+	if err := QueryRuleInto(cmp, "test", "counts", nil, nil, &counts); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if counts["a"] != 1 || counts["b"] != 2 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+}
+
+func TestQueryRuleIntoDecodeErr(t *testing.T) {
+	cmp := setup(`
+	package test
+
+	users = [{"name": "alice", "age": 30}, {"name": "bob", "age": 300}]
+	`)
+
+	var users []decodeUser
+	err := QueryRuleInto(cmp, "test", "users", nil, nil, &users)
+	if !IsDecodeErr(err) {
+		t.Fatalf("expected DecodeErr, got %v", err)
+	}
+	if e := err.(*DecodeErr); e.Path != "data.test.users[1].age" {
+		t.Fatalf("unexpected path %v", e.Path)
+	}
+
+	var name int
+	err = QueryRuleInto(cmp, "test", "users", nil, nil, &name)
+	if !IsDecodeErr(err) {
+		t.Fatalf("expected DecodeErr, got %v", err)
+	}
+
+	if err := QueryRuleInto(cmp, "test", "users", nil, nil, users); !IsDecodeErr(err) {
+		t.Fatalf("expected DecodeErr for non-pointer, got %v", err)
+	}
+}
+
+func TestQueryRuleIntoUndefined(t *testing.T) {
+	cmp := setup(`
+	package test
+
+	x { false }
+	`)
+
+	var x bool
+	if err := QueryRuleInto(cmp, "test", "x", nil, nil, &x); !IsUndefined(err) {
+		t.Fatalf("expected UndefinedErr, got %v", err)
+	}
+}
+
+type decodeBase struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type decodeAudit struct {
+	Created string `json:"created"`
+}
+
+type decodeTimestamps struct {
+	Updated string `json:"updated"`
+}
+
+type decodeSecret string
+
+type decodeEmbedded struct {
+	decodeBase
+	decodeSecret
+	decodeTimestamps
+	Name string `json:"name"`
+}
+
+type decodeUnexportedPtr struct {
+	*decodeAudit
+	Name string `json:"name"`
+}
+
+type DecodeOwner struct {
+	Owner string `json:"owner"`
+}
+
+type decodeExported struct {
+	*DecodeOwner
+	Meta decodeBase `json:"meta"`
+}
+
+func TestDecodeEmbedded(t *testing.T) {
+	value := map[string]interface{}{
+		"id":           "1",
+		"name":         "outer",
+		"updated":      "wednesday",
+		"decodeSecret": "hidden",
+	}
+
+	var e decodeEmbedded
+	if err := Decode(value, &e); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if e.ID != "1" || e.Updated != "wednesday" {
+		t.Fatalf("expected fields of embedded structs to be promoted, got %+v", e)
+	}
+	if e.Name != "outer" || e.decodeBase.Name != "" {
+		t.Fatalf("expected the outer field to hide the embedded one, got %+v", e)
+	}
+	if e.decodeSecret != "" {
+		t.Fatalf("expected unexported embedded non-structs to be skipped, got %+v", e)
+	}
+
+	// as with encoding/json, an unexported embedded pointer cannot be allocated
+	var u decodeUnexportedPtr
+	err := Decode(map[string]interface{}{"name": "alice", "created": "monday"}, &u)
+	if e, ok := err.(*DecodeErr); !ok || e.Path != "result.created" {
+		t.Fatalf("expected DecodeErr at result.created, got %v", err)
+	}
+
+	var x decodeExported
+	value = map[string]interface{}{"owner": "alice", "meta": map[string]interface{}{"id": "2"}}
+	if err := Decode(value, &x); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if x.DecodeOwner == nil || x.Owner != "alice" || x.Meta.ID != "2" {
+		t.Fatalf("expected embedded pointer to be allocated and tagged struct to be decoded by name, got %+v", x)
+	}
+}
+
+type decodeTeam struct {
+	Members []decodeUser `json:"members"`
+}
+
+func TestDecodeNestedPath(t *testing.T) {
+	value := map[string]interface{}{
+		"members": []interface{}{
+			map[string]interface{}{"name": "alice", "age": 30},
+			map[string]interface{}{"name": "bob", "age": "old"},
+		},
+	}
+	var team decodeTeam
+	err := Decode(value, &team)
+	if !IsDecodeErr(err) {
+		t.Fatalf("expected DecodeErr, got %v", err)
+	}
+	if e := err.(*DecodeErr); e.Path != "result.members[1].age" || e.Message != "cannot decode string into uint8" {
+		t.Fatalf("unexpected error %v", e)
+	}
+}
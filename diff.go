@@ -0,0 +1,88 @@
+package rego
+
+import (
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// ModulesEqual returns true if a and b define the same package, imports and rules. Locations and comments are
+// ignored, so a module is equal to itself after a round trip through any of the serialization formats.
+func ModulesEqual(a, b *ast.Module) bool {
+	return a.Compare(b) == 0
+}
+
+// ModuleDiff lists the rules that differ between two versions of a set of modules. Rules are identified by their
+// full path, such as data.example.allow, and all the definitions of a rule are compared together.
+type ModuleDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty returns true if the two versions define the same rules.
+func (d *ModuleDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffModules reports the rules that were added, removed or changed between prev and next, for instance to decide
+// whether a policy change needs to be redeployed. Locations, comments and the files rules are defined in are ignored.
+func DiffModules(prev, next map[string]*ast.Module) *ModuleDiff {
+	before := rulesByPath(prev)
+	after := rulesByPath(next)
+
+	diff := &ModuleDiff{
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+		Changed: make([]string, 0),
+	}
+	for path, rules := range before {
+		other, ok := after[path]
+		if !ok {
+			diff.Removed = append(diff.Removed, path)
+		} else if !rulesEqual(rules, other) {
+			diff.Changed = append(diff.Changed, path)
+		}
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			diff.Added = append(diff.Added, path)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// rulesByPath groups the rules in modules by their full path. Each group is sorted so that groups can be compared
+// regardless of the order the rules were defined in.
+func rulesByPath(modules map[string]*ast.Module) map[string][]*ast.Rule {
+	rules := make(map[string][]*ast.Rule)
+	for _, module := range modules {
+		if module == nil {
+			continue
+		}
+		for _, rule := range module.Rules {
+			path := module.Package.Path.Append(ast.StringTerm(string(rule.Head.Name))).String()
+			rules[path] = append(rules[path], rule)
+		}
+	}
+	for _, group := range rules {
+		sort.Slice(group, func(i, j int) bool { return group[i].Compare(group[j]) < 0 })
+	}
+	return rules
+}
+
+func rulesEqual(a, b []*ast.Rule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Compare(b[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
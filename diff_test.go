@@ -0,0 +1,59 @@
+package rego
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestModulesEqualIgnoresLocationsAndComments(t *testing.T) {
+	a := mustParse(t, "a.rego", "package test\n\n# explains x\nx = 1\n")
+	b := mustParse(t, "b.rego", "package test\nx = 1")
+	if !ModulesEqual(a, b) {
+		t.Fatalf("expected modules to be equal")
+	}
+	c := mustParse(t, "c.rego", "package test\nx = 2")
+	if ModulesEqual(a, c) {
+		t.Fatalf("expected modules to differ")
+	}
+}
+
+func TestDiffModules(t *testing.T) {
+	prev := map[string]*ast.Module{
+		"a.rego": mustParse(t, "a.rego", `
+		package test
+		allow { input.admin }
+		allow { input.owner }
+		deny { input.banned }
+		limit = 10
+		`),
+	}
+	next := map[string]*ast.Module{
+		"a.rego": mustParse(t, "a.rego", `
+		package test
+		# reordered and commented
+		allow { input.owner }
+		limit = 20
+		`),
+		"b.rego": mustParse(t, "b.rego", `
+		package test
+		allow { input.admin }
+		audit { true }
+		`),
+	}
+
+	diff := DiffModules(prev, next)
+	expected := &ModuleDiff{
+		Added:   []string{"data.test.audit"},
+		Removed: []string{"data.test.deny"},
+		Changed: []string{"data.test.limit"},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, diff)
+	}
+
+	if diff := DiffModules(next, next); !diff.Empty() {
+		t.Fatalf("expected no differences, got %+v", diff)
+	}
+}
@@ -0,0 +1,186 @@
+package rego
+
+import (
+	"context"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+// Engine owns a set of modules, the compiler they are compiled into and the data store they are queried against.
+// It is safe for concurrent use. Changes to the module set are compiled into a new compiler which is swapped in
+// atomically, so queries that are already running are never blocked or affected by them.
+type Engine struct {
+	// wmtx serializes changes to the module set. mtx only guards swapping in the result of a change.
+	wmtx    sync.Mutex
+	mtx     sync.RWMutex
+	modules map[string]*ast.Module
+	cmp     *ast.Compiler
+	// queries caches the queries prepared against cmp. It is replaced together with cmp.
+	queries *queryCache
+	store   storage.Store
+}
+
+// NewEngine creates an Engine with no modules that queries against store. If store is nil an empty in-memory store
+// is used. An error is returned if the empty module set cannot be compiled.
+func NewEngine(store storage.Store) (*Engine, error) {
+	if store == nil {
+		store = inmem.New()
+	}
+	cmp := NewCompiler()
+	if err := Compile(cmp, map[string]*ast.Module{}); err != nil {
+		return nil, err
+	}
+	return &Engine{
+		modules: map[string]*ast.Module{},
+		cmp:     cmp,
+		queries: newQueryCache(cmp),
+		store:   store,
+	}, nil
+}
+
+// AddModule adds module under name, replacing any module already stored under that name, and recompiles. If
+// compilation fails the engine is left unchanged and the compilation errors are returned.
+func (e *Engine) AddModule(name string, module *ast.Module) error {
+	e.wmtx.Lock()
+	defer e.wmtx.Unlock()
+
+	modules := e.Modules()
+	modules[name] = module
+	return e.compile(modules)
+}
+
+// RemoveModule removes the module stored under name and recompiles. Removing a module that does not exist is not an
+// error. If compilation fails the engine is left unchanged and the compilation errors are returned.
+func (e *Engine) RemoveModule(name string) error {
+	e.wmtx.Lock()
+	defer e.wmtx.Unlock()
+
+	modules := e.Modules()
+	if _, ok := modules[name]; !ok {
+		return nil
+	}
+	delete(modules, name)
+	return e.compile(modules)
+}
+
+// Reload parses the files in fpaths and replaces the engine's entire module set with them. The new modules are
+// compiled into a new compiler which is only swapped in if parsing and compilation succeed; otherwise the previous
+// modules keep serving queries and the errors are returned as *Errors.
+func (e *Engine) Reload(fpaths []string) error {
+	modules, err := ParseFiles(fpaths)
+	if err != nil {
+		return err
+	}
+	return e.reload(modules)
+}
+
+// ReloadBytes is like Reload but parses the contents of files, which are keyed by filename.
+func (e *Engine) ReloadBytes(files map[string][]byte) error {
+	errs := new(Errors)
+	modules := make(map[string]*ast.Module, len(files))
+	for fname, data := range files {
+		module, err := ParseBytes(fname, data)
+		errs.Add(err)
+		modules[fname] = module
+	}
+	if err := errs.NilIfEmpty(); err != nil {
+		return err
+	}
+	return e.reload(modules)
+}
+
+func (e *Engine) reload(modules map[string]*ast.Module) error {
+	e.wmtx.Lock()
+	defer e.wmtx.Unlock()
+
+	// Compile already returns *Errors, which is passed on as it is
+	err := e.compile(modules)
+	if _, ok := err.(*Errors); err == nil || ok {
+		return err
+	}
+	errs := new(Errors)
+	errs.Add(err)
+	return errs
+}
+
+// Modules returns a copy of the set of modules held by the engine.
+func (e *Engine) Modules() map[string]*ast.Module {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	modules := make(map[string]*ast.Module, len(e.modules))
+	for name, module := range e.modules {
+		modules[name] = module
+	}
+	return modules
+}
+
+// Compiler returns the compiler the engine currently queries against. It must not be recompiled by the caller.
+func (e *Engine) Compiler() *ast.Compiler {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.cmp
+}
+
+// Store returns the data store owned by the engine.
+func (e *Engine) Store() storage.Store {
+	return e.store
+}
+
+// Query runs query against the engine's compiler and store. See Query.
+func (e *Engine) Query(query string, input interface{}) (rego.ResultSet, error) {
+	return e.QueryContext(context.Background(), query, input)
+}
+
+// QueryContext is like Query but evaluates under ctx. See QueryContext.
+func (e *Engine) QueryContext(ctx context.Context, query string, input interface{}) (rego.ResultSet, error) {
+	pq, err := e.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return pq.Eval(ctx, input, &e.store)
+}
+
+// QueryRule queries a single rule against the engine's compiler and store. See QueryRule.
+func (e *Engine) QueryRule(pkg, rule string, input interface{}) (interface{}, error) {
+	return e.QueryRuleContext(context.Background(), pkg, rule, input)
+}
+
+// QueryRuleContext is like QueryRule but evaluates under ctx. See QueryRuleContext.
+func (e *Engine) QueryRuleContext(ctx context.Context, pkg, rule string, input interface{}) (interface{}, error) {
+	pq, err := e.prepare(ruleQuery(pkg, rule))
+	if err != nil {
+		return nil, err
+	}
+	return pq.evalRule(ctx, rule, input, &e.store)
+}
+
+// prepare prepares query against the engine's current compiler, using the engine's own cache rather than the one
+// shared by Prepare.
+func (e *Engine) prepare(query string) (*PreparedQuery, error) {
+	e.mtx.RLock()
+	cmp, queries := e.cmp, e.queries
+	e.mtx.RUnlock()
+	return queries.prepare(cmp, query)
+}
+
+// compile compiles modules into a fresh compiler and swaps both in, together with an empty query cache for the new
+// compiler, on success. Compilation happens without holding mtx so that queries keep running against the previous
+// compiler in the meantime. The caller must hold wmtx.
+func (e *Engine) compile(modules map[string]*ast.Module) error {
+	cmp := NewCompiler()
+	if err := Compile(cmp, modules); err != nil {
+		return err
+	}
+	queries := newQueryCache(cmp)
+
+	e.mtx.Lock()
+	e.modules = modules
+	e.cmp = cmp
+	e.queries = queries
+	e.mtx.Unlock()
+	return nil
+}
@@ -0,0 +1,140 @@
+package rego
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+func mustParse(t *testing.T, name, policy string) *ast.Module {
+	mod, err := ParseBytes(name, []byte(policy))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return mod
+}
+
+func mustEngine(t *testing.T, store storage.Store) *Engine {
+	e, err := NewEngine(store)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return e
+}
+
+func TestEngineAddModule(t *testing.T) {
+	e := mustEngine(t, inmem.NewFromObject(map[string]interface{}{"b": 2}))
+	err := e.AddModule("test", mustParse(t, "test", `
+	package test
+	eval = x { x := input.a + data.b }
+	`))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	res, err := e.QueryRule("test", "eval", map[string]interface{}{"a": 1})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 3)
+}
+
+func TestEngineAddModuleFailure(t *testing.T) {
+	e := mustEngine(t, nil)
+	if err := e.AddModule("test", mustParse(t, "test", "package test\neval = 1")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	err := e.AddModule("bad", mustParse(t, "bad", "package bad\neval = x"))
+	if err == nil {
+		t.Fatalf("did not catch compilation error")
+	}
+	if _, ok := e.Modules()["bad"]; ok {
+		t.Fatalf("failed module should not have been added")
+	}
+	res, err := e.QueryRule("test", "eval", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 1)
+}
+
+func TestEngineRemoveModule(t *testing.T) {
+	e := mustEngine(t, nil)
+	if err := e.AddModule("test", mustParse(t, "test", "package test\neval = 1")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := e.RemoveModule("test"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err := e.QueryRule("test", "eval", nil)
+	if !IsUndefined(err) {
+		t.Fatalf("expected undefined error, got %v", err)
+	}
+	if err := e.RemoveModule("missing"); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestEngineReloadBytes(t *testing.T) {
+	e := mustEngine(t, nil)
+	err := e.ReloadBytes(map[string][]byte{"test.rego": []byte("package test\neval = 1")})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	err = e.ReloadBytes(map[string][]byte{"test.rego": []byte("package test\neval = x")})
+	if err == nil {
+		t.Fatalf("did not catch compilation error")
+	}
+	errs, ok := err.(*Errors)
+	if !ok {
+		t.Fatalf("incorrect error type")
+	}
+	for _, member := range *errs {
+		if _, ok := member.(*CompileErr); !ok {
+			t.Fatalf("expected the compile errors to be returned as they are, got %v", member)
+		}
+	}
+	res, err := e.QueryRule("test", "eval", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 1)
+
+	err = e.ReloadBytes(map[string][]byte{"test.rego": []byte("package test\neval = 2")})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	res, err = e.QueryRule("test", "eval", nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 2)
+}
+
+func TestEngineReloadConcurrent(t *testing.T) {
+	e := mustEngine(t, nil)
+	if err := e.ReloadBytes(map[string][]byte{"test.rego": []byte("package test\neval = true")}); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := e.QueryRule("test", "eval", nil); err != nil {
+				t.Errorf(err.Error())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := e.ReloadBytes(map[string][]byte{"test.rego": []byte("package test\neval = true")}); err != nil {
+				t.Errorf(err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+}
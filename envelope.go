@@ -0,0 +1,268 @@
+package rego
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Format identifies an encoding of a module
+type Format byte
+
+const (
+	FormatJson Format = iota + 1
+	FormatGob
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatJson:
+		return "json"
+	case FormatGob:
+		return "gob"
+	default:
+		return fmt.Sprintf("format(%d)", byte(f))
+	}
+}
+
+// envelopeMagic begins every envelope written by SerializeModuleEnvelope
+var envelopeMagic = []byte("RGEV")
+
+// envelopeVersion is the version of the envelope layout. It must be incremented whenever the layout changes.
+const envelopeVersion = 1
+
+// astFormatVersion identifies the AST of the OPA release this package is built against by its major and minor
+// version; OPA does not change its AST in patch releases.
+var astFormatVersion = opaMinorVersion()
+
+// defaultASTFormatVersion is the AST format version used when the version of OPA cannot be read from the build
+// information, e.g. when OPA is replaced by a local directory. It must be bumped when OPA is upgraded to a new minor
+// release.
+const defaultASTFormatVersion = "0.14"
+
+// opaModule is the module path of OPA
+const opaModule = "github.com/open-policy-agent/opa"
+
+// opaMinorVersion returns the major and minor version of the OPA module the binary is built with. version.Version
+// cannot be used instead as it is only set when OPA itself is built.
+func opaMinorVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return defaultASTFormatVersion
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != opaModule {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		parts := strings.SplitN(strings.TrimPrefix(dep.Version, "v"), ".", 3)
+		if len(parts) < 2 {
+			return defaultASTFormatVersion
+		}
+		return parts[0] + "." + parts[1]
+	}
+	return defaultASTFormatVersion
+}
+
+// SerializeModuleEnvelope serializes module in the given format and wraps it in a self-describing envelope recording
+// the format, the AST format version and a SHA-256 digest of the payload. DeserializeModuleJson,
+// DeserializeModuleGob and DeserializeModuleEnvelope verify the envelope before decoding, so corrupted or truncated
+// data and data written against an OPA release with a different AST are rejected with an EnvelopeErr.
+//
+// Only the output of SerializeModuleEnvelope and SerializeModuleCompressed is protected this way. SerializeModuleJson,
+// SerializeModuleGob, SerializeModuleBinary and SerializeModuleJsonWithLocations write no envelope, and
+// DeserializeModuleJson and DeserializeModuleGob accept data without one unchecked. To reject such data, read it with
+// DeserializeModuleEnvelope; to migrate data written before envelopes were used, read it with
+// DeserializeModuleEnvelopeOrLegacy.
+//
+// The envelope is laid out as: magic, envelope version, format, length prefixed AST format version, digest, length
+// prefixed payload.
+func SerializeModuleEnvelope(module *ast.Module, format Format) ([]byte, error) {
+	var payload []byte
+	var err error
+	switch format {
+	case FormatJson:
+		payload, err = SerializeModuleJson(module)
+	case FormatGob:
+		payload, err = SerializeModuleGob(module)
+	default:
+		return nil, NewEnvelopeError(fmt.Sprintf("unknown format %v", format))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return seal(format, payload), nil
+}
+
+// DeserializeModuleEnvelope verifies an envelope written by SerializeModuleEnvelope and decodes the module it holds
+// using the format it records. Unlike the deserializers of each format, it rejects data without an envelope, so it
+// should be used whenever the data is expected to have been written by SerializeModuleEnvelope.
+func DeserializeModuleEnvelope(data []byte) (*ast.Module, error) {
+	data, err := Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	if !hasEnvelope(data) {
+		return nil, NewEnvelopeError("missing envelope")
+	}
+	return decodeEnvelope(data)
+}
+
+// DeserializeModuleEnvelopeOrLegacy is like DeserializeModuleEnvelope, except that data without an envelope is
+// accepted unchecked and decoded as legacy, the format it was written in. It is meant for reading data written both
+// before and after envelopes were adopted; once all data carries an envelope, use DeserializeModuleEnvelope.
+func DeserializeModuleEnvelopeOrLegacy(data []byte, legacy Format) (*ast.Module, error) {
+	data, err := Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	if !hasEnvelope(data) {
+		return decodePayload(legacy, data)
+	}
+	return decodeEnvelope(data)
+}
+
+// decodeEnvelope verifies the envelope in data and decodes the module it holds
+func decodeEnvelope(data []byte) (*ast.Module, error) {
+	format, payload, err := unseal(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodePayload(format, payload)
+}
+
+// decodePayload decodes a module serialized in format without an envelope. The payload of an envelope is not
+// decompressed or unsealed again.
+func decodePayload(format Format, payload []byte) (*ast.Module, error) {
+	switch format {
+	case FormatJson:
+		return decodeModuleJson(payload)
+	case FormatGob:
+		return DecodeModule(bytes.NewReader(payload), FormatGob)
+	default:
+		return nil, NewEnvelopeError(fmt.Sprintf("unknown format %v", format))
+	}
+}
+
+// IsEnvelopeErr returns true if the given error is or wraps an EnvelopeErr
+func IsEnvelopeErr(err error) bool {
+	var e *EnvelopeErr
+	return errors.As(err, &e)
+}
+
+// seal wraps payload in an envelope
+func seal(format Format, payload []byte) []byte {
+	return sealVersion(format, astFormatVersion, payload)
+}
+
+// sealVersion wraps payload in an envelope recording the given AST format version
+func sealVersion(format Format, astVersion string, payload []byte) []byte {
+	digest := sha256.Sum256(payload)
+	buf := new(bytes.Buffer)
+	buf.Write(envelopeMagic)
+	buf.WriteByte(envelopeVersion)
+	buf.WriteByte(byte(format))
+	writeUvarintBytes(buf, []byte(astVersion))
+	buf.Write(digest[:])
+	writeUvarintBytes(buf, payload)
+	return buf.Bytes()
+}
+
+// unseal verifies the envelope in data and returns the format and payload it holds
+func unseal(data []byte) (Format, []byte, error) {
+	r := bytes.NewReader(data[len(envelopeMagic):])
+
+	v, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, NewEnvelopeError("truncated envelope")
+	}
+	if v != envelopeVersion {
+		return 0, nil, NewEnvelopeError(fmt.Sprintf("unsupported envelope version %v", v))
+	}
+
+	f, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, NewEnvelopeError("truncated envelope")
+	}
+
+	astVersion, err := readUvarintBytes(r)
+	if err != nil {
+		return 0, nil, NewEnvelopeError("truncated envelope")
+	}
+	if string(astVersion) != astFormatVersion {
+		msg := fmt.Sprintf("module serialized with the AST of OPA %v cannot be read with the AST of OPA %v",
+			string(astVersion), astFormatVersion)
+		return 0, nil, NewEnvelopeError(msg)
+	}
+
+	digest := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, digest); err != nil {
+		return 0, nil, NewEnvelopeError("truncated envelope")
+	}
+
+	payload, err := readUvarintBytes(r)
+	if err != nil {
+		return 0, nil, NewEnvelopeError("truncated envelope")
+	}
+	if r.Len() != 0 {
+		return 0, nil, NewEnvelopeError("trailing data after envelope")
+	}
+	if sum := sha256.Sum256(payload); !bytes.Equal(sum[:], digest) {
+		return 0, nil, NewEnvelopeError("digest mismatch: module is corrupted")
+	}
+	return Format(f), payload, nil
+}
+
+// openEnvelope decompresses data and returns its payload if it is an envelope holding format. Data without an
+// envelope is returned as it is, unverified, so that modules serialized before envelopes existed can still be read.
+// Callers that must not accept such data use DeserializeModuleEnvelope instead.
+func openEnvelope(data []byte, format Format) ([]byte, error) {
+	data, err := Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	if !hasEnvelope(data) {
+		return data, nil
+	}
+	f, payload, err := unseal(data)
+	if err != nil {
+		return nil, err
+	}
+	if f != format {
+		return nil, NewEnvelopeError(fmt.Sprintf("envelope holds %v, expected %v", f, format))
+	}
+	return payload, nil
+}
+
+func hasEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, envelopeMagic)
+}
+
+func writeUvarintBytes(buf *bytes.Buffer, data []byte) {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lenBuf, uint64(len(data)))
+	buf.Write(lenBuf[:n])
+	buf.Write(data)
+}
+
+func readUvarintBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, fmt.Errorf("length %v exceeds remaining %v bytes", n, r.Len())
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
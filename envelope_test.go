@@ -0,0 +1,114 @@
+package rego
+
+import (
+	"testing"
+)
+
+const envelopePolicy = `
+	package test
+	default allow = false
+	allow { input.admin }
+	`
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatJson, FormatGob} {
+		mod := mustParse(t, "test", envelopePolicy)
+		data, err := SerializeModuleEnvelope(mod, format)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		result, err := DeserializeModuleEnvelope(data)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		if len(result.Rules) != 2 {
+			t.Fatalf("%v: expected 2 rules, got %v", format, len(result.Rules))
+		}
+	}
+}
+
+func TestEnvelopeVerifiedByDeserializers(t *testing.T) {
+	data, err := SerializeModuleEnvelope(mustParse(t, "test", envelopePolicy), FormatJson)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := DeserializeModuleJson(data); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := DeserializeModuleGob(data); !IsEnvelopeErr(err) {
+		t.Fatalf("expected format mismatch to be caught, got %v", err)
+	}
+}
+
+func TestEnvelopeCorrupted(t *testing.T) {
+	data, err := SerializeModuleEnvelope(mustParse(t, "test", envelopePolicy), FormatJson)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-2] ^= 0xff
+	if _, err := DeserializeModuleJson(corrupted); !IsEnvelopeErr(err) {
+		t.Fatalf("expected corruption to be caught, got %v", err)
+	}
+
+	truncated := data[:len(data)-10]
+	if _, err := DeserializeModuleJson(truncated); !IsEnvelopeErr(err) {
+		t.Fatalf("expected truncation to be caught, got %v", err)
+	}
+}
+
+func TestEnvelopeLegacy(t *testing.T) {
+	data, err := SerializeModuleJson(mustParse(t, "test", envelopePolicy))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := DeserializeModuleJson(data); err != nil {
+		t.Fatalf("expected data without an envelope to be accepted, got %v", err)
+	}
+	if _, err := DeserializeModuleEnvelope(data); !IsEnvelopeErr(err) {
+		t.Fatalf("expected missing envelope to be caught, got %v", err)
+	}
+}
+
+func TestEnvelopeASTVersion(t *testing.T) {
+	payload, err := SerializeModuleJson(mustParse(t, "test", envelopePolicy))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	data := sealVersion(FormatJson, "0.13", payload)
+	if _, err := DeserializeModuleEnvelope(data); !IsEnvelopeErr(err) {
+		t.Fatalf("expected a different AST format to be caught, got %v", err)
+	}
+}
+
+func TestEnvelopeOrLegacy(t *testing.T) {
+	mod := mustParse(t, "test", envelopePolicy)
+	legacy, err := SerializeModuleGob(mod)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := DeserializeModuleEnvelopeOrLegacy(legacy, FormatGob); err != nil {
+		t.Fatalf("expected data without an envelope to be accepted, got %v", err)
+	}
+
+	data, err := SerializeModuleEnvelope(mod, FormatJson)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// the format recorded in the envelope takes precedence over the legacy one
+	if _, err := DeserializeModuleEnvelopeOrLegacy(data, FormatGob); err != nil {
+		t.Fatalf(err.Error())
+	}
+	data[len(data)-2] ^= 0xff
+	if _, err := DeserializeModuleEnvelopeOrLegacy(data, FormatGob); !IsEnvelopeErr(err) {
+		t.Fatalf("expected corruption to be caught, got %v", err)
+	}
+}
+
+func TestEnvelopeDefaultASTVersion(t *testing.T) {
+	if astFormatVersion != defaultASTFormatVersion {
+		t.Fatalf("built against the AST of OPA %v, bump defaultASTFormatVersion from %v",
+			astFormatVersion, defaultASTFormatVersion)
+	}
+}
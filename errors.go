@@ -1,14 +1,115 @@
 package rego
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"bufio"
+	"io"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown"
 )
 
+// Location is the position in a policy file that an error refers to
+type Location struct {
+	File string `json:"file,omitempty"`
+	Row  int    `json:"row"`
+	Col  int    `json:"col"`
+}
+
+func newLocation(loc *ast.Location) *Location {
+	if loc == nil {
+		return nil
+	}
+	return &Location{File: loc.File, Row: loc.Row, Col: loc.Col}
+}
+
+func (l *Location) String() string {
+	return fmt.Sprintf("%v:%v:%v", l.File, l.Row, l.Col)
+}
+
+// formatError formats an error in the same way as ast.Error, with each line of details on a line of its own
+func formatError(loc *Location, code, msg string, details []string) string {
+	msg = fmt.Sprintf("%v: %v", code, msg)
+	if loc != nil {
+		if len(loc.File) > 0 {
+			msg = fmt.Sprintf("%v:%v: %v", loc.File, loc.Row, msg)
+		} else {
+			msg = fmt.Sprintf("%v:%v: %v", loc.Row, loc.Col, msg)
+		}
+	}
+	for _, line := range details {
+		msg += "\n\t" + line
+	}
+	return msg
+}
+
+// errorDetails returns the lines of the details of e, e.g. the expected and actual types of a type error
+func errorDetails(e *ast.Error) []string {
+	if e.Details == nil {
+		return nil
+	}
+	return e.Details.Lines()
+}
+
+// ParseErr represents error generated while parsing a policy
+type ParseErr struct {
+	// Code identifies the kind of error, e.g. rego_parse_error
+	Code     string
+	Message  string
+	Location *Location
+	// Details holds further lines describing the error, if any
+	Details []string
+	// Cause is the error reported by the OPA parser, if any
+	Cause *ast.Error
+}
+
+func (e *ParseErr) Error() string {
+	return formatError(e.Location, e.Code, e.Message, e.Details)
+}
+
+func (e *ParseErr) Unwrap() error {
+	if e.Cause == nil {
+		return nil
+	}
+	return e.Cause
+}
+
+// CompileErr represents error generated while compiling policies
+type CompileErr struct {
+	// Code identifies the kind of error, e.g. rego_unsafe_var_error
+	Code     string
+	Message  string
+	Location *Location
+	// Details holds further lines describing the error, e.g. the expected and actual types of a type error
+	Details []string
+	// Rule is the name of the rule the error was found in, if any
+	Rule string
+	// Cause is the error reported by the OPA compiler, if any
+	Cause *ast.Error
+}
+
+func (e *CompileErr) Error() string {
+	return formatError(e.Location, e.Code, e.Message, e.Details)
+}
+
+func (e *CompileErr) Unwrap() error {
+	if e.Cause == nil {
+		return nil
+	}
+	return e.Cause
+}
+
 // EvalErr represents error generated during evaluation of a query
 type EvalErr struct {
 	Message string
+	// Query is the query that was being evaluated
+	Query string
+	// Code identifies the kind of error, e.g. rego_type_error or eval_conflict_error, if known
+	Code     string
+	Location *Location
+	// Cause is the error returned by OPA, if any
+	Cause error
 }
 
 // NewEvalError creates a new EvalError with message msg
@@ -18,10 +119,50 @@ func NewEvalError(msg string) *EvalErr {
 	}
 }
 
+// newQueryError creates a new EvalErr for err, produced while evaluating query. The code and location are taken
+// from err if it is an OPA error.
+func newQueryError(query string, err error) *EvalErr {
+	e := &EvalErr{
+		Message: err.Error(),
+		Query:   query,
+		Cause:   err,
+	}
+	switch v := err.(type) {
+	case ast.Errors:
+		if len(v) > 0 {
+			e.Code, e.Location = v[0].Code, newLocation(v[0].Location)
+		}
+	case *ast.Error:
+		e.Code, e.Location = v.Code, newLocation(v.Location)
+	case *topdown.Error:
+		e.Code, e.Location = v.Code, newLocation(v.Location)
+	}
+	return e
+}
+
 func (e *EvalErr) Error() string {
+	if e.Query != "" {
+		return e.Query + ": " + e.Message
+	}
 	return e.Message
 }
 
+func (e *EvalErr) Unwrap() error {
+	return e.Cause
+}
+
+// Is returns true if target is an *EvalErr, so that errors.Is(err, &EvalErr{}) reports whether err is an EvalErr.
+func (e *EvalErr) Is(target error) bool {
+	_, ok := target.(*EvalErr)
+	return ok
+}
+
+// IsEvalErr returns true if the given error is or wraps an EvalErr
+func IsEvalErr(err error) bool {
+	var e *EvalErr
+	return errors.As(err, &e)
+}
+
 // UndefinedErr represents error caused by no results in evaluation
 type UndefinedErr struct {
 	Message string
@@ -36,6 +177,72 @@ func (e *UndefinedErr) Error() string {
 	return e.Message
 }
 
+// Is returns true if target is an *UndefinedErr, so that errors.Is(err, &UndefinedErr{}) reports whether err is an
+// UndefinedErr.
+func (e *UndefinedErr) Is(target error) bool {
+	_, ok := target.(*UndefinedErr)
+	return ok
+}
+
+// IsUndefined returns true if the given error is or wraps an UndefinedErr
+func IsUndefined(err error) bool {
+	var e *UndefinedErr
+	return errors.As(err, &e)
+}
+
+// CancelErr represents error caused by the context of a query being cancelled or timing out before evaluation completed
+type CancelErr struct {
+	Message string
+	// Cause is the error of the context, either context.Canceled or context.DeadlineExceeded
+	Cause error
+}
+
+// NewCancelError creates a new CancelErr with the given message
+func NewCancelError(msg string) *CancelErr {
+	return &CancelErr{Message: msg}
+}
+
+// newContextError creates a new CancelErr for the error of the context query was being evaluated under
+func newContextError(query string, err error) *CancelErr {
+	return &CancelErr{Message: query + ": " + err.Error(), Cause: err}
+}
+
+func (e *CancelErr) Error() string {
+	return e.Message
+}
+
+func (e *CancelErr) Unwrap() error {
+	return e.Cause
+}
+
+// Is returns true if target is a *CancelErr, so that errors.Is(err, &CancelErr{}) reports whether err is a
+// CancelErr. errors.Is(err, context.DeadlineExceeded) can be used to tell timeouts apart from cancellation.
+func (e *CancelErr) Is(target error) bool {
+	_, ok := target.(*CancelErr)
+	return ok
+}
+
+// IsCancelled returns true if the given error is or wraps a CancelErr
+func IsCancelled(err error) bool {
+	var e *CancelErr
+	return errors.As(err, &e)
+}
+
+// EnvelopeErr represents error caused by serialized data whose envelope is missing, corrupted or was written by an
+// incompatible version
+type EnvelopeErr struct {
+	Message string
+}
+
+// NewEnvelopeError creates a new EnvelopeErr with the given message
+func NewEnvelopeError(msg string) *EnvelopeErr {
+	return &EnvelopeErr{Message: msg}
+}
+
+func (e *EnvelopeErr) Error() string {
+	return e.Message
+}
+
 // Errors represents multiple errors
 type Errors []error
 
@@ -58,6 +265,33 @@ func (e *Errors) Error() string {
 	return fmt.Sprintf("%v errors:\n%v", len(buf), strings.Join(buf, "\n"))
 }
 
+// Unwrap returns the errors held by e, so that errors.Is and errors.As examine each of them.
+func (e *Errors) Unwrap() []error {
+	return *e
+}
+
+// As lets errors.As(err, &astErrs), with astErrs an ast.Errors, collect the OPA errors that the ParseErrs and
+// CompileErrs in e were made from. ParseBytes and Compile used to return ast.Errors, so code that looks for them
+// keeps working.
+func (e *Errors) As(target interface{}) bool {
+	t, ok := target.(*ast.Errors)
+	if !ok {
+		return false
+	}
+	astErrs := make(ast.Errors, 0)
+	for _, err := range *e {
+		var astErr *ast.Error
+		if errors.As(err, &astErr) {
+			astErrs = append(astErrs, astErr)
+		}
+	}
+	if len(astErrs) == 0 {
+		return false
+	}
+	*t = astErrs
+	return true
+}
+
 func (e *Errors) NilIfEmpty() error {
 	if len(*e) == 0 {
 		return nil
@@ -71,6 +305,12 @@ type ErrWriter struct {
 	w   *bufio.Writer
 }
 
+// NewErrWriter creates a new ErrWriter that buffers writes to w. Once a write fails, every later write and flush is
+// skipped and the first error is reported by Error.
+func NewErrWriter(w io.Writer) *ErrWriter {
+	return &ErrWriter{w: bufio.NewWriter(w)}
+}
+
 func (ew *ErrWriter) Write(data interface{}) {
 
 	if ew.err != nil {
@@ -0,0 +1,111 @@
+package rego
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+)
+
+// SerializeModuleRego converts module into canonically formatted Rego source, as produced by `opa fmt`. The output
+// is human readable, diffs cleanly and can be read back with ParseBytes. Comments and the layout of rules are taken
+// from the locations in module. A module without locations, such as one read by DeserializeModuleJson, is formatted
+// from its parsed String form instead, so its comments are lost but the output is the same as for the original.
+// module is not modified.
+func SerializeModuleRego(module *ast.Module) (formatted []byte, err error) {
+	// the formatter panics on some ASTs without locations
+	defer func() {
+		if r := recover(); r != nil {
+			formatted, err = nil, fmt.Errorf("cannot format module: %v", r)
+		}
+	}()
+	if module.Package != nil && module.Package.Location == nil {
+		reparsed, parseErr := ast.ParseModule("", someDeclsToCalls(module.Copy()).String())
+		if parseErr != nil {
+			return nil, fmt.Errorf("cannot format module: %v", parseErr)
+		}
+		module = callsToSomeDecls(reparsed)
+	} else {
+		// the formatter renames the heads of else rules in place
+		module = module.Copy()
+	}
+	return format.Ast(module)
+}
+
+// someDeclPlaceholder names the call that stands in for a `some` declaration while a module is printed and reparsed,
+// since SomeDecl prints itself as `var x`, which does not parse
+const someDeclPlaceholder = "__rego_some_decl__"
+
+// someDeclsToCalls replaces the `some` declarations in module with calls to someDeclPlaceholder, in place
+func someDeclsToCalls(module *ast.Module) *ast.Module {
+	ast.WalkExprs(module, func(expr *ast.Expr) bool {
+		if decl, ok := expr.Terms.(*ast.SomeDecl); ok {
+			expr.Terms = append([]*ast.Term{ast.RefTerm(ast.VarTerm(someDeclPlaceholder))}, decl.Symbols...)
+		}
+		return false
+	})
+	return module
+}
+
+// callsToSomeDecls undoes someDeclsToCalls, in place
+func callsToSomeDecls(module *ast.Module) *ast.Module {
+	ast.WalkExprs(module, func(expr *ast.Expr) bool {
+		if expr.IsCall() && expr.Operator().String() == someDeclPlaceholder {
+			expr.Terms = &ast.SomeDecl{Symbols: expr.Operands(), Location: expr.Location}
+		}
+		return false
+	})
+	return module
+}
+
+// FormatModules formats every module in modules, for instance as loaded by ParseFiles, and returns the formatted
+// source under the same keys. A module that cannot be formatted is left out of the result and reported in the
+// returned *Errors under its key, so the rest can still be written out.
+func FormatModules(modules map[string]*ast.Module) (map[string][]byte, error) {
+	errs := new(Errors)
+	formatted := make(map[string][]byte, len(modules))
+	for name, module := range modules {
+		data, err := SerializeModuleRego(module)
+		if err != nil {
+			errs.Add(fmt.Errorf("%v: %w", name, err))
+			continue
+		}
+		formatted[name] = data
+	}
+	return formatted, errs.NilIfEmpty()
+}
+
+// FormatFiles formats the Rego files in fpaths and returns the paths of the files that were not already formatted.
+// If write is true those files are overwritten with their formatted source, like `opa fmt -w`.
+func FormatFiles(fpaths []string, write bool) ([]string, error) {
+	errs := new(Errors)
+	changed := make([]string, 0)
+	for _, fpath := range fpaths {
+		src, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		formatted, err := format.Source(fpath, src)
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		if bytes.Equal(src, formatted) {
+			continue
+		}
+		changed = append(changed, fpath)
+		if write {
+			info, err := os.Stat(fpath)
+			if err != nil {
+				errs.Add(err)
+				continue
+			}
+			errs.Add(ioutil.WriteFile(fpath, formatted, info.Mode()))
+		}
+	}
+	return changed, errs.NilIfEmpty()
+}
@@ -0,0 +1,133 @@
+package rego
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestModuleSerializationRego(t *testing.T) {
+	mod := mustParse(t, "test.rego", binaryPolicy)
+	data, err := SerializeModuleRego(mod)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !mod.Equal(mustParse(t, "test.rego", binaryPolicy)) {
+		t.Fatalf("module modified by formatting:\n%v", mod)
+	}
+	result, err := ParseBytes("test.rego", data)
+	if err != nil {
+		t.Fatalf("formatted source does not parse: %v\n%s", err, data)
+	}
+	if !result.Equal(mod) {
+		t.Fatalf("different modules produced:\n%v\n%v", mod, result)
+	}
+
+	// formatting is canonical, so formatting the output again changes nothing
+	again, err := SerializeModuleRego(result)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(again) != string(data) {
+		t.Fatalf("formatting is not stable:\n%s\n%s", data, again)
+	}
+}
+
+func TestModuleSerializationRegoWithoutLocations(t *testing.T) {
+	// OPA cannot read `some` back from JSON, so only the binary format carries it
+	policies := map[string]string{
+		"binary": `
+		package test
+		import data.other
+
+		default allow = false
+		allow { input.admin; not input.deny }
+		x[i] { some i; input.a[i] }
+		pairs = {[k, v] | some k, v; input.m[k] = v}
+		level = "high" { input.score > 5 } else = "low" { count(input.tags) > 1 with input.tags as [1] }
+		`,
+		"json": `
+		package test
+		allow { input.admin; not input.deny }
+		level = "high" { input.score > 5 } else = "low" { true }
+		`,
+	}
+	for name, policy := range policies {
+		mod := mustParse(t, "test.rego", policy)
+		expected, err := SerializeModuleRego(mod)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		var deserialized *ast.Module
+		if name == "binary" {
+			data, err := SerializeModuleBinary(mod, false)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			deserialized, err = DeserializeModuleBinary(data)
+		} else {
+			data, err := SerializeModuleJson(mod)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			deserialized, err = DeserializeModuleJson(data)
+		}
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		data, err := SerializeModuleRego(deserialized)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if string(data) != string(expected) {
+			t.Fatalf("%v: expected the module to be formatted as the original:\n%s\n%s", name, expected, data)
+		}
+	}
+}
+
+func TestFormatFiles(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"messy.rego": "package a\nx=1\nallow{input.admin==true}",
+		"clean.rego": "package b\n\ny = 2\n",
+	})
+	defer os.RemoveAll(root)
+	messy := filepath.Join(root, "messy.rego")
+	clean := filepath.Join(root, "clean.rego")
+
+	changed, err := FormatFiles([]string{messy, clean}, true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(changed) != 1 || changed[0] != messy {
+		t.Fatalf("expected only %v to change, got %v", messy, changed)
+	}
+
+	changed, err = FormatFiles([]string{messy, clean}, false)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(changed) != 0 {
+		t.Fatalf("expected formatted files to be left alone, got %v", changed)
+	}
+
+	modules, err := ParseFiles([]string{messy})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	formatted, err := FormatModules(modules)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	src, err := ioutil.ReadFile(messy)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(formatted[messy]) != string(src) {
+		t.Fatalf("expected FormatModules to match the formatted file:\n%s\n%s", formatted[messy], src)
+	}
+}
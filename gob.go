@@ -0,0 +1,83 @@
+package rego
+
+import (
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// The implementations of ast.Set and ast.Object keep their elements in unexported fields, so Gob cannot encode
+// them. Before a module is Gob encoded every set and object in it is replaced by a gobSet or gobObject, which hold
+// the same terms in exported form, and after it is decoded they are turned back into sets and objects.
+
+// gobSet holds the elements of an ast.Set while it is Gob encoded
+type gobSet []*ast.Term
+
+// gobObject holds the key-value pairs of an ast.Object while it is Gob encoded
+type gobObject [][2]*ast.Term
+
+func (s gobSet) set() ast.Set {
+	return ast.NewSet(s...)
+}
+
+func (s gobSet) Compare(other ast.Value) int          { return s.set().Compare(other) }
+func (s gobSet) Find(path ast.Ref) (ast.Value, error) { return s.set().Find(path) }
+func (s gobSet) Hash() int                            { return s.set().Hash() }
+func (s gobSet) IsGround() bool                       { return s.set().IsGround() }
+func (s gobSet) String() string                       { return s.set().String() }
+
+func (o gobObject) object() ast.Object {
+	return ast.NewObject(o...)
+}
+
+func (o gobObject) Compare(other ast.Value) int          { return o.object().Compare(other) }
+func (o gobObject) Find(path ast.Ref) (ast.Value, error) { return o.object().Find(path) }
+func (o gobObject) Hash() int                            { return o.object().Hash() }
+func (o gobObject) IsGround() bool                       { return o.object().IsGround() }
+func (o gobObject) String() string                       { return o.object().String() }
+
+// toGobValues replaces every set and object in x with a gobSet or gobObject. x is modified in place.
+func toGobValues(x interface{}) {
+	ast.WalkTerms(x, func(term *ast.Term) bool {
+		switch v := term.Value.(type) {
+		case ast.Set:
+			elems := make(gobSet, 0, v.Len())
+			v.Foreach(func(elem *ast.Term) {
+				toGobValues(elem)
+				elems = append(elems, elem)
+			})
+			term.Value = elems
+			return true
+		case ast.Object:
+			pairs := make(gobObject, 0, v.Len())
+			v.Foreach(func(key, value *ast.Term) {
+				toGobValues(key)
+				toGobValues(value)
+				pairs = append(pairs, [2]*ast.Term{key, value})
+			})
+			term.Value = pairs
+			return true
+		}
+		return false
+	})
+}
+
+// fromGobValues turns every gobSet and gobObject in x back into a set or object. x is modified in place.
+func fromGobValues(x interface{}) {
+	ast.WalkTerms(x, func(term *ast.Term) bool {
+		switch v := term.Value.(type) {
+		case gobSet:
+			for _, elem := range v {
+				fromGobValues(elem)
+			}
+			term.Value = v.set()
+			return true
+		case gobObject:
+			for _, pair := range v {
+				fromGobValues(pair[0])
+				fromGobValues(pair[1])
+			}
+			term.Value = v.object()
+			return true
+		}
+		return false
+	})
+}
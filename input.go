@@ -0,0 +1,100 @@
+package rego
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// InputErr represents error caused by an input that cannot be represented as a Rego value
+type InputErr struct {
+	Message string
+	// Cause is the error returned while converting the input, if any
+	Cause error
+}
+
+// NewInputError creates a new InputErr with the given message
+func NewInputError(msg string) *InputErr {
+	return &InputErr{Message: msg}
+}
+
+func (e *InputErr) Error() string {
+	return e.Message
+}
+
+func (e *InputErr) Unwrap() error {
+	return e.Cause
+}
+
+// IsInputErr returns true if the given error is or wraps an InputErr
+func IsInputErr(err error) bool {
+	var e *InputErr
+	return errors.As(err, &e)
+}
+
+// InputValue converts input to the Rego value the query functions evaluate against. input may be an ast.Value or
+// *ast.Term, which are used as they are, a json.RawMessage holding a JSON document, or any value that can be
+// marshaled to JSON, such as a struct or map. A nil input, including a nil map or pointer, reaches policies as null,
+// as it did when input could only be a map.
+//
+// The query functions call InputValue themselves; callers evaluating several queries against the same input can call
+// it once and pass the resulting ast.Value to avoid converting the input each time.
+func InputValue(input interface{}) (ast.Value, error) {
+	switch v := input.(type) {
+	case nil:
+		return ast.Null{}, nil
+	case ast.Value:
+		return v, nil
+	case *ast.Term:
+		if v == nil {
+			return ast.Null{}, nil
+		}
+		return v.Value, nil
+	case json.RawMessage:
+		if len(v) == 0 {
+			return ast.Null{}, nil
+		}
+		var x interface{}
+		if err := util.UnmarshalJSON(v, &x); err != nil {
+			return nil, &InputErr{Message: fmt.Sprintf("invalid JSON input: %v", err), Cause: err}
+		}
+		input = x
+	}
+
+	if rv := reflect.ValueOf(input); isNilValue(rv) {
+		return ast.Null{}, nil
+	}
+
+	value, err := ast.InterfaceToValue(input)
+	if err == nil {
+		return value, nil
+	}
+
+	// OPA only converts the types produced by decoding JSON, so anything else, such as a struct or a typed map,
+	// anywhere in input is converted to them through its JSON form
+	var converted interface{}
+	data, err := json.Marshal(input)
+	if err == nil {
+		err = util.UnmarshalJSON(data, &converted)
+	}
+	if err != nil {
+		return nil, &InputErr{Message: fmt.Sprintf("cannot convert input of type %T: %v", input, err), Cause: err}
+	}
+	value, err = ast.InterfaceToValue(converted)
+	if err != nil {
+		return nil, &InputErr{Message: fmt.Sprintf("cannot convert input of type %T: %v", input, err), Cause: err}
+	}
+	return value, nil
+}
+
+func isNilValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package rego
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+const inputPolicy = `
+	package test
+
+	allow { input.user.name == "alice"; input.user.roles[_] == "admin" }
+`
+
+type inputUser struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+type inputRequest struct {
+	User inputUser `json:"user"`
+}
+
+func TestQueryTypedInput(t *testing.T) {
+	cmp := setup(inputPolicy)
+
+	inputs := map[string]interface{}{
+		"struct":  inputRequest{User: inputUser{Name: "alice", Roles: []string{"admin"}}},
+		"pointer": &inputRequest{User: inputUser{Name: "alice", Roles: []string{"admin"}}},
+		"raw":     json.RawMessage(`{"user": {"name": "alice", "roles": ["admin"]}}`),
+		"value":   ast.MustParseTerm(`{"user": {"name": "alice", "roles": ["admin"]}}`).Value,
+		"map":     map[string]interface{}{"user": map[string]interface{}{"name": "alice", "roles": []interface{}{"admin"}}},
+		"nested":  map[string]interface{}{"user": inputUser{Name: "alice", Roles: []string{"admin"}}},
+	}
+	for note, input := range inputs {
+		res, err := QueryRule(cmp, "test", "allow", input, nil)
+		if err != nil {
+			t.Fatalf("%v: %v", note, err)
+		}
+		validate(t, res, true)
+	}
+}
+
+func TestQueryNilInput(t *testing.T) {
+	cmp := setup(`
+	package test
+
+	isnull { input == null }
+	`)
+
+	var req *inputRequest
+	var term *ast.Term
+	for _, input := range []interface{}{nil, req, map[string]interface{}(nil), term, json.RawMessage(nil)} {
+		res, err := QueryRule(cmp, "test", "isnull", input, nil)
+		if err != nil {
+			t.Fatalf("expected %T to reach the policy as null, got %v", input, err)
+		}
+		validate(t, res, true)
+	}
+}
+
+func TestQueryInvalidInput(t *testing.T) {
+	cmp := setup(inputPolicy)
+
+	_, err := QueryRule(cmp, "test", "allow", json.RawMessage(`{"user":`), nil)
+	if !IsInputErr(err) {
+		t.Fatalf("expected InputErr for invalid JSON, got %v", err)
+	}
+
+	_, err = QueryRule(cmp, "test", "allow", map[string]interface{}{"c": make(chan int)}, nil)
+	if !IsInputErr(err) {
+		t.Fatalf("expected InputErr for unmarshalable input, got %v", err)
+	}
+}
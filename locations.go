@@ -0,0 +1,125 @@
+package rego
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// locatedModule is the document written by SerializeModuleJsonWithLocations. Locations holds the location of every
+// node in the module, in the order the nodes are visited by ast.Walk. Each location records the kind of node it
+// belongs to, so that a module whose nodes are visited in a different order is rejected instead of being given the
+// wrong locations.
+type locatedModule struct {
+	Module    json.RawMessage `json:"module"`
+	Locations []*location     `json:"locations"`
+}
+
+// location is the JSON representation of an ast.Location
+type location struct {
+	Node string `json:"node"`
+	File string `json:"file,omitempty"`
+	Row  int    `json:"row"`
+	Col  int    `json:"col"`
+	Text string `json:"text,omitempty"`
+}
+
+// SerializeModuleJsonWithLocations converts into a JSON document like SerializeModuleJson, but also stores the file,
+// row, column and source text of every term, expression and rule. Errors produced when compiling or querying the
+// deserialized module therefore still point to the original source.
+func SerializeModuleJsonWithLocations(module *ast.Module) ([]byte, error) {
+	data, err := SerializeModuleJson(module)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := locatedModule{Module: data, Locations: make([]*location, 0)}
+	walkLocations(module, func(node string, loc **ast.Location) {
+		if *loc == nil {
+			doc.Locations = append(doc.Locations, &location{Node: node})
+			return
+		}
+		doc.Locations = append(doc.Locations, &location{
+			Node: node,
+			File: (*loc).File,
+			Row:  (*loc).Row,
+			Col:  (*loc).Col,
+			Text: string((*loc).Text),
+		})
+	})
+	return json.Marshal(doc)
+}
+
+// DeserializeModuleJsonWithLocations reads a module written by SerializeModuleJsonWithLocations. Like
+// DeserializeModuleJson, compressed data is decompressed and an envelope is verified first. An error is returned if
+// the stored locations do not line up with the nodes of the module.
+func DeserializeModuleJsonWithLocations(data []byte) (*ast.Module, error) {
+	data, err := openEnvelope(data, FormatJson)
+	if err != nil {
+		return nil, err
+	}
+	var doc locatedModule
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Module) == 0 {
+		return nil, fmt.Errorf("document holds no module")
+	}
+	module, err := decodeModuleJson(doc.Module)
+	if err != nil {
+		return nil, err
+	}
+
+	// the locations are checked against the nodes before any is set, so a mismatch leaves module without locations
+	// rather than with the wrong ones
+	nodes := make([]string, 0, len(doc.Locations))
+	walkLocations(module, func(node string, loc **ast.Location) {
+		nodes = append(nodes, node)
+	})
+	if len(nodes) != len(doc.Locations) {
+		return nil, fmt.Errorf("module has %v nodes but %v locations were stored", len(nodes), len(doc.Locations))
+	}
+	for i, l := range doc.Locations {
+		if l == nil || l.Node != nodes[i] {
+			return nil, fmt.Errorf("location %v does not belong to a %v node", i, nodes[i])
+		}
+	}
+
+	i := 0
+	walkLocations(module, func(node string, loc **ast.Location) {
+		if l := doc.Locations[i]; l.Row != 0 || l.File != "" {
+			*loc = &ast.Location{File: l.File, Row: l.Row, Col: l.Col, Text: []byte(l.Text)}
+		}
+		i++
+	})
+	return module, nil
+}
+
+// walkLocations calls f with the kind and a pointer to the location of every node in module, in the order ast.Walk
+// visits them.
+func walkLocations(module *ast.Module, f func(node string, loc **ast.Location)) {
+	ast.Walk(ast.NewGenericVisitor(func(x interface{}) bool {
+		switch node := x.(type) {
+		case *ast.Package:
+			f("package", &node.Location)
+		case *ast.Import:
+			f("import", &node.Location)
+		case *ast.Rule:
+			f("rule", &node.Location)
+		case *ast.Head:
+			f("head", &node.Location)
+		case *ast.Expr:
+			f("expr", &node.Location)
+		case *ast.Term:
+			f("term", &node.Location)
+		case *ast.With:
+			f("with", &node.Location)
+		case *ast.SomeDecl:
+			f("some", &node.Location)
+		case *ast.Comment:
+			f("comment", &node.Location)
+		}
+		return false
+	}), module)
+}
@@ -6,11 +6,20 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-// ParseBytes parses data. fname is used to write error messages.
+// ParseBytes parses data. fname is used to write error messages. Syntax errors are returned as *Errors holding a
+// *ParseErr for each error. The ast.Errors returned by the OPA parser can still be retrieved with errors.As.
 func ParseBytes(fname string, data []byte) (*ast.Module, error) {
-	return ast.ParseModule(fname, string(data))
+	module, err := ast.ParseModule(fname, string(data))
+	if err != nil {
+		return nil, newParseErrors(err)
+	}
+	return module, nil
 }
 
 // ParseFile parses the file specified by fpath and returns a module
@@ -19,7 +28,33 @@ func ParseFile(fpath string) (*ast.Module, error) {
 	if err != nil {
 		return nil, err
 	}
-	return ast.ParseModule(fpath, string(file))
+	return ParseBytes(fpath, file)
+}
+
+// IsParseErr returns true if the given error is or wraps a ParseErr, including through *Errors
+func IsParseErr(err error) bool {
+	var e *ParseErr
+	return errors.As(err, &e)
+}
+
+// newParseErrors converts the errors returned by the OPA parser into *Errors holding a *ParseErr for each. Other
+// errors are returned as they are.
+func newParseErrors(err error) error {
+	astErrs, ok := err.(ast.Errors)
+	if !ok {
+		return err
+	}
+	errs := new(Errors)
+	for _, e := range astErrs {
+		errs.Add(&ParseErr{
+			Code:     e.Code,
+			Message:  e.Message,
+			Location: newLocation(e.Location),
+			Details:  errorDetails(e),
+			Cause:    e,
+		})
+	}
+	return errs.NilIfEmpty()
 }
 
 // ParseFiles parses all the files in fpaths and returns a map[string]*ast.Module where the filenames are the keys
@@ -34,6 +69,79 @@ func ParseFiles(fpaths []string) (map[string]*ast.Module, error) {
 	return modules, errs.NilIfEmpty()
 }
 
+// ParseOptions controls which files ParseDir and ParseGlob load
+type ParseOptions struct {
+	// SkipTests skips files ending in _test.rego
+	SkipTests bool
+	// SkipHidden skips files and directories whose names begin with "."
+	SkipHidden bool
+}
+
+// ParseDir recursively parses every .rego file under root and returns a map[string]*ast.Module where the file paths
+// are the keys. A file that fails to parse does not stop the others from being parsed; the parse errors of all of
+// them are returned together.
+func ParseDir(root string, opts ParseOptions) (map[string]*ast.Module, error) {
+	fpaths, err := regoFiles(root, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFiles(fpaths)
+}
+
+// ParseGlob parses every .rego file matching pattern, as understood by filepath.Match. Matching directories are
+// loaded recursively as by ParseDir.
+func ParseGlob(pattern string, opts ParseOptions) (map[string]*ast.Module, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := new(Errors)
+	fpaths := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		files, err := regoFiles(match, opts)
+		errs.Add(err)
+		for _, f := range files {
+			if !seen[f] {
+				seen[f] = true
+				fpaths = append(fpaths, f)
+			}
+		}
+	}
+
+	modules, err := ParseFiles(fpaths)
+	errs.Add(err)
+	return modules, errs.NilIfEmpty()
+}
+
+// regoFiles returns the paths of all the .rego files under root accepted by opts. If root is a file it is returned
+// as long as opts accepts it.
+func regoFiles(root string, opts ParseOptions) ([]string, error) {
+	fpaths := make([]string, 0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if opts.SkipHidden && path != root && strings.HasPrefix(name, ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !strings.HasSuffix(name, ".rego") {
+			return nil
+		}
+		if opts.SkipTests && strings.HasSuffix(name, "_test.rego") {
+			return nil
+		}
+		fpaths = append(fpaths, path)
+		return nil
+	})
+	return fpaths, err
+}
+
 // SerializeModuleJson converts into a JSON document. The document should always be pre-compiled and checked for correctness
 // as the JSON document does not store the locations of any of the elements in the AST. This means that error messages
 // during compilation post deserialization will be terrible.
@@ -42,8 +150,20 @@ func SerializeModuleJson(module *ast.Module) ([]byte, error) {
 	return json.Marshal(*module)
 }
 
-// DeserializeModuleJson reads a module from a Json byte array. The AST produced has no location fields.
+// DeserializeModuleJson reads a module from a Json byte array. The AST produced has no location fields. If data is
+// wrapped in an envelope written by SerializeModuleEnvelope, the envelope is verified first. Data without an envelope,
+// such as the output of SerializeModuleJson, is decoded without any check; use DeserializeModuleEnvelope to require
+// one.
 func DeserializeModuleJson(data []byte) (*ast.Module, error) {
+	data, err := openEnvelope(data, FormatJson)
+	if err != nil {
+		return nil, err
+	}
+	return decodeModuleJson(data)
+}
+
+// decodeModuleJson reads a module from the document written by SerializeModuleJson
+func decodeModuleJson(data []byte) (*ast.Module, error) {
 	var module = &ast.Module{}
 	err := json.Unmarshal(data, module)
 	if err != nil {
@@ -54,38 +174,41 @@ func DeserializeModuleJson(data []byte) (*ast.Module, error) {
 }
 
 // SerializeModuleGob uses Gob to convert into a byte array. The disadvantage of this method is that it is less space
-// efficient as it stores all the location fields
-// TODO: test this function
+// efficient as it stores all the location fields. module is not modified, so it is safe to serialize a module that is
+// shared with goroutines compiling, querying or serializing it.
 func SerializeModuleGob(module *ast.Module) ([]byte, error) {
 	buf := new(bytes.Buffer)
-	removeModuleFromRules(module)
-	enc := gob.NewEncoder(buf)
-	err := enc.Encode(*module)
+	err := EncodeModule(buf, module, FormatGob)
 	return buf.Bytes(), err
 }
 
-// DeserializeModuleGob uses Gob to deserialize.
-// TODO: test this function
+// DeserializeModuleGob uses Gob to deserialize. If data is wrapped in an envelope written by SerializeModuleEnvelope,
+// the envelope is verified first. Data without an envelope, such as the output of SerializeModuleGob, is decoded
+// without any check; use DeserializeModuleEnvelope to require one.
 func DeserializeModuleGob(data []byte) (*ast.Module, error) {
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	var mod ast.Module
-	err := dec.Decode(&mod)
-	addModuleToRules(&mod)
-	return &mod, err
+	data, err := openEnvelope(data, FormatGob)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeModule(bytes.NewReader(data), FormatGob)
 }
 
-// Removes the circular module reference from each rule
+// Removes the circular module reference from each rule and its else branches. This modifies module, so it must only be called on a copy
+// of a module owned by the caller.
 func removeModuleFromRules(module *ast.Module) {
 	for _, rule := range module.Rules {
-		rule.Module = nil
+		for r := rule; r != nil; r = r.Else {
+			r.Module = nil
+		}
 	}
 }
 
-// Adds the module reference to each rule
+// Adds the module reference to each rule, including the else branches chained to it
 func addModuleToRules(module *ast.Module) {
 	for _, rule := range module.Rules {
-		rule.Module = module
+		for r := rule; r != nil; r = r.Else {
+			r.Module = module
+		}
 	}
 }
 
@@ -167,27 +290,31 @@ func addModuleToRules(module *ast.Module) {
 //	}
 //}
 
+// The types that OPA stores in interfaces as pointers, such as the terms of an expression, are registered as pointers
+// so that they are decoded as pointers too.
 func init() {
 	gob.Register(ast.Var(""))
 	gob.Register(ast.String(""))
 	gob.Register(ast.Ref{})
+	gob.Register([]*ast.Term{})
 	gob.Register(ast.Array{})
-	gob.Register(ast.ArrayComprehension{})
+	gob.Register(&ast.ArrayComprehension{})
 	gob.Register(ast.Boolean(true))
 	gob.Register(ast.Builtin{})
 	gob.Register(ast.Call{})
 	gob.Register(ast.Comment{})
 	gob.Register(ast.Null{})
-	//gob.Register(ast.Object(nil))
-	gob.Register(ast.ObjectComprehension{})
-	//gob.Register(ast.Set(nil))
-	gob.Register(ast.SetComprehension{})
+	gob.Register(gobObject{})
+	gob.Register(&ast.ObjectComprehension{})
+	gob.Register(gobSet{})
+	gob.Register(&ast.SetComprehension{})
+	gob.Register(&ast.SomeDecl{})
 	gob.Register(ast.Module{})
 	gob.Register(ast.Error{})
 	gob.Register(ast.Errors{})
 	gob.Register(ast.Body{})
 	gob.Register(ast.Number(json.Number("5")))
-	gob.Register(ast.Term{})
+	gob.Register(&ast.Term{})
 	gob.Register(ast.Head{})
 	gob.Register(ast.Rule{})
 	gob.Register(ast.RuleSet{})
@@ -1,11 +1,14 @@
 package rego
 
 import (
+	"errors"
 	"testing"
 	"github.com/open-policy-agent/opa/ast"
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
 func testSerialization(def string, t *testing.T) {
@@ -39,22 +42,12 @@ func testSerialization(def string, t *testing.T) {
 		t.Fatalf("uncompilable: " + err.Error())
 	}
 
-	//if !reflect.DeepEqual(*result, *mod) {
-	//	t.Fatalf("not deeply equal")
-	//}
-
-	// two are equal if the pretty printed representations of the two are equal
-	ast1 := new(bytes.Buffer)
-	ast.Pretty(ast1, mod)
-	ast2 := new(bytes.Buffer)
-	ast.Pretty(ast2, mod)
-
-	if string(ast1.Bytes()) != string(ast2.Bytes()) {
+	if !ModulesEqual(result, mod) {
 		t.Logf("Different parse trees produced:\n")
 		t.Logf("Input:\n")
-		t.Logf(string(ast1.Bytes()))
+		t.Logf(mod.String())
 		t.Logf("\nOutput:\n")
-		t.Fatalf(string(ast2.Bytes()))
+		t.Fatalf(result.String())
 	}
 }
 
@@ -95,4 +88,226 @@ func TestModuleSerializationComplex(t *testing.T) {
 	}
 	`
 	testSerialization(def, t)
-}
\ No newline at end of file
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "rego")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), os.ModePerm); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	return root
+}
+
+func TestParseDir(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.rego":          "package a\nx = 1",
+		"sub/b.rego":      "package b\nx = 2",
+		"sub/b_test.rego": "package b\ntest_x { x == 2 }",
+		".hidden/c.rego":  "package c\nx = 3",
+		"sub/notes.txt":   "not a policy",
+	})
+	defer os.RemoveAll(root)
+
+	mods, err := ParseDir(root, ParseOptions{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(mods) != 4 {
+		t.Fatalf("expected 4 modules, got %v", len(mods))
+	}
+
+	mods, err = ParseDir(root, ParseOptions{SkipTests: true, SkipHidden: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(mods) != 2 {
+		t.Fatalf("expected 2 modules, got %v", len(mods))
+	}
+	if _, ok := mods[filepath.Join(root, "sub", "b.rego")]; !ok {
+		t.Fatalf("expected sub/b.rego to be loaded")
+	}
+}
+
+func TestParseDirErrors(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.rego": "package a\nx = ",
+		"b.rego": "package b\ny = ",
+	})
+	defer os.RemoveAll(root)
+
+	_, err := ParseDir(root, ParseOptions{})
+	errs, ok := err.(*Errors)
+	if !ok {
+		t.Fatalf("expected *Errors, got %v", err)
+	}
+	files := map[string]bool{}
+	for _, e := range *errs {
+		if pe, ok := e.(*ParseErr); ok && pe.Location != nil {
+			files[filepath.Base(pe.Location.File)] = true
+		}
+	}
+	if !files["a.rego"] || !files["b.rego"] {
+		t.Fatalf("expected errors from both files, got %v", err)
+	}
+}
+
+func TestParseGlob(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"policies/a.rego":      "package a\nx = 1",
+		"policies/a_test.rego": "package a\ntest_x { x == 1 }",
+		"other/b.rego":         "package b\nx = 2",
+	})
+	defer os.RemoveAll(root)
+
+	mods, err := ParseGlob(filepath.Join(root, "pol*"), ParseOptions{SkipTests: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(mods) != 1 {
+		t.Fatalf("expected 1 module, got %v", len(mods))
+	}
+}
+
+func TestModuleSerializationGob(t *testing.T) {
+	tests := []struct {
+		note   string
+		policy string
+	}{
+		{"scalars", `x = [null, true, false, 1, -2, 3.5, "s"]`},
+		{"refs", `x = input.a.b[0]`},
+		{"set", `x = {1, 2, {3, "4"}}`},
+		{"empty set", `x = set()`},
+		{"object", `x = {"a": 1, "b": {"c": [1, 2]}}`},
+		{"object with set values", `x = {"a": {1, 2}, "b": {"c": {3}}}`},
+		{"array comprehension", "x = [y | y = s[_]]\ns = {1, 2}"},
+		{"set comprehension", "x = {y | y = a[_]}\na = [{\"a\": 1}]"},
+		{"object comprehension", "x = {k: v | v = o[k]}\no = {\"a\": {1}}"},
+		{"calls", `x = y { y := count({1, 2}) + 1 }`},
+		{"negation", `x { not input.deny }`},
+		{"with", `x { count(input) > 0 with input as {"a": {1}} }`},
+		{"some", `x[i] { some i; input.a[i] }`},
+		{"else", `x = 1 { input.a } else = {2} { true }`},
+		{"functions", `f(a) = b { b := {a} }`},
+		{"partial object", "x[k] = v { v := o[k] }\no = {\"a\": 1}"},
+		{"default", `default x = {"a": set()}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			mod := mustParse(t, "test.rego", "package test\n\nimport data.other\n\n"+tc.policy)
+			data, err := SerializeModuleGob(mod)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			result, err := DeserializeModuleGob(data)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if !result.Equal(mod) {
+				t.Fatalf("different modules produced:\n%v\n%v", mod, result)
+			}
+			if result.Rules[0].Module != result {
+				t.Fatalf("expected rules to reference their module")
+			}
+			if result.Rules[0].Location == nil || result.Rules[0].Location.Row != 5 {
+				t.Fatalf("expected locations to be preserved")
+			}
+			if err := Compile(NewCompiler(), map[string]*ast.Module{"test": result}); err != nil {
+				t.Fatalf("uncompilable: " + err.Error())
+			}
+		})
+	}
+}
+
+func TestModuleSerializationGobDoesNotMutate(t *testing.T) {
+	mod := mustParse(t, "test.rego", binaryPolicy)
+	before := mod.String()
+
+	if _, err := SerializeModuleGob(mod); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, rule := range mod.Rules {
+		if rule.Module != mod {
+			t.Fatalf("rule %v lost its module reference", rule.Head.Name)
+		}
+	}
+	if mod.String() != before {
+		t.Fatalf("module modified by serialization:\n%v\n%v", before, mod)
+	}
+
+	// the module must still compile and answer queries as before
+	cmp := NewCompiler()
+	if err := Compile(cmp, map[string]*ast.Module{"test": mod}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	res, err := QueryRule(cmp, "test", "v6", nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 10)
+}
+
+func TestModuleSerializationGobConcurrent(t *testing.T) {
+	mod := mustParse(t, "test.rego", binaryPolicy)
+	expected, err := SerializeModuleGob(mod)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := SerializeModuleGob(mod)
+			if err != nil {
+				t.Errorf(err.Error())
+				return
+			}
+			if !bytes.Equal(data, expected) {
+				t.Errorf("concurrent serialization produced different output")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+
+func TestParseErr(t *testing.T) {
+	_, err := ParseBytes("policy.rego", []byte("package test\n\nallow {\n\tinput.x ==\n}\n"))
+	if !IsParseErr(err) {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+	pe := (*err.(*Errors))[0].(*ParseErr)
+	if pe.Code != "rego_parse_error" {
+		t.Fatalf("expected rego_parse_error, got %v", pe.Code)
+	}
+	if pe.Location == nil || pe.Location.File != "policy.rego" || pe.Location.Row < 4 {
+		t.Fatalf("unexpected location %v", pe.Location)
+	}
+}
+
+func TestParseErrASTErrors(t *testing.T) {
+	_, err := ParseBytes("policy.rego", []byte("package test\n\nallow {\n\tinput.x ==\n}\n"))
+	var astErrs ast.Errors
+	if !errors.As(err, &astErrs) {
+		t.Fatalf("expected ast.Errors to be reachable, got %v", err)
+	}
+	if len(astErrs) == 0 || astErrs[0].Code != ast.ParseErr {
+		t.Fatalf("unexpected OPA errors %v", astErrs)
+	}
+	var astErr *ast.Error
+	if !errors.As(err, &astErr) || astErr.Location.File != "policy.rego" {
+		t.Fatalf("expected the OPA error to be the cause, got %v", astErr)
+	}
+}
@@ -0,0 +1,99 @@
+package rego
+
+import (
+	"context"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// Residual is the result of partially evaluating a query. The query is true for some value of the unknowns if and
+// only if at least one of Queries is true for it. Support holds the modules defining rules that Queries refer to
+// but that partial evaluation did not inline.
+type Residual struct {
+	Queries []ast.Body
+	Support []*ast.Module
+}
+
+// Undefined returns true if the query is false or undefined whatever the values of the unknowns are
+func (r *Residual) Undefined() bool {
+	return len(r.Queries) == 0
+}
+
+// Unconditional returns true if the query is true whatever the values of the unknowns are
+func (r *Residual) Unconditional() bool {
+	for _, q := range r.Queries {
+		if len(q) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryStrings returns the residual queries in Rego syntax
+func (r *Residual) QueryStrings() []string {
+	qs := make([]string, len(r.Queries))
+	for i, q := range r.Queries {
+		qs[i] = q.String()
+	}
+	return qs
+}
+
+func (r *Residual) String() string {
+	return strings.Join(r.QueryStrings(), "\n")
+}
+
+// PartialQuery partially evaluates query on the given compiler, treating the references in unknowns, e.g.
+// input.resource, as unknown. Everything that does not depend on the unknowns is evaluated against input and store;
+// what remains is returned as a Residual.
+func PartialQuery(cmp *ast.Compiler, query string, unknowns []string, input interface{}, store *storage.Store) (*Residual, error) {
+	return PartialQueryContext(context.Background(), cmp, query, unknowns, input, store)
+}
+
+// PartialQueryContext is like PartialQuery but evaluates under ctx. The query is compiled but, unlike with Prepare,
+// not planned for evaluation, since partial evaluation does not use the plan.
+func PartialQueryContext(ctx context.Context, cmp *ast.Compiler, query string, unknowns []string, input interface{}, store *storage.Store) (*Residual, error) {
+	pq, err := compileQuery(cmp, query)
+	if err != nil {
+		return nil, err
+	}
+	return pq.Partial(ctx, unknowns, input, store)
+}
+
+// Partial partially evaluates the prepared query. It behaves like PartialQueryContext.
+func (pq *PreparedQuery) Partial(ctx context.Context, unknowns []string, input interface{}, store *storage.Store) (*Residual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, newContextError(pq.query, err)
+	}
+
+	value, err := InputValue(input)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []func(r *rego.Rego){
+		rego.ParsedQuery(pq.body),
+		rego.Compiler(pq.cmp),
+		rego.Unknowns(unknowns),
+	}
+
+	if value != nil {
+		args = append(args, rego.ParsedInput(value))
+	}
+
+	if store != nil {
+		args = append(args, rego.Store(*store))
+	}
+
+	pqs, err := rego.New(args...).Partial(ctx)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, newContextError(pq.query, ctxErr)
+		}
+		return nil, newQueryError(pq.query, err)
+	}
+
+	return &Residual{Queries: pqs.Queries, Support: pqs.Support}, nil
+}
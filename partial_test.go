@@ -0,0 +1,57 @@
+package rego
+
+import (
+	"strings"
+	"testing"
+)
+
+const partialPolicy = `
+	package test
+
+	allow { input.user == "admin" }
+	allow { input.resource.owner == input.user }
+	allow { input.resource.public }
+`
+
+func TestPartialQuery(t *testing.T) {
+	cmp := setup(partialPolicy)
+
+	residual, err := PartialQuery(cmp, "data.test.allow == true", []string{"input.resource"}, map[string]interface{}{"user": "bob"}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if residual.Undefined() || residual.Unconditional() {
+		t.Fatalf("expected conditional residual, got %v", residual)
+	}
+	if len(residual.Queries) != 2 {
+		t.Fatalf("expected 2 residual queries, got %v", residual)
+	}
+	s := residual.String()
+	if !strings.Contains(s, "input.resource.owner") || !strings.Contains(s, "input.resource.public") {
+		t.Fatalf("unexpected residual queries %v", s)
+	}
+	if strings.Contains(s, "input.user") {
+		t.Fatalf("expected known input to be evaluated, got %v", s)
+	}
+}
+
+func TestPartialQueryUnconditional(t *testing.T) {
+	cmp := setup(partialPolicy)
+
+	residual, err := PartialQuery(cmp, "data.test.allow == true", []string{"input.resource"}, map[string]interface{}{"user": "admin"}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !residual.Unconditional() {
+		t.Fatalf("expected unconditional residual, got %v", residual)
+	}
+}
+
+func TestPartialQueryInvalid(t *testing.T) {
+	cmp := setup(partialPolicy)
+
+	_, err := PartialQuery(cmp, "data.test.allow ==", []string{"input.resource"}, nil, nil)
+	if !IsEvalErr(err) {
+		t.Fatalf("expected EvalErr, got %v", err)
+	}
+}
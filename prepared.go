@@ -0,0 +1,267 @@
+package rego
+
+import (
+	"container/list"
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// PreparedQuery is a query that has been parsed and planned against a compiler once so that it can be evaluated
+// many times. It is safe for concurrent use.
+type PreparedQuery struct {
+	cmp   *ast.Compiler
+	query string
+	body  ast.Body
+
+	// OPA binds a planned query to the store it reads from, so the query is planned once for every store it is
+	// evaluated against. The plan for the nil store, which evaluates against an empty store, is made by Prepare.
+	// planLRU holds the plans, most recently used first, and plans indexes it by store.
+	mtx     sync.Mutex
+	plans   map[storage.Store]*list.Element
+	planLRU *list.List
+}
+
+// storePlan is a query planned against store
+type storePlan struct {
+	store storage.Store
+	plan  rego.PreparedEvalQuery
+}
+
+// maxPlansPerQuery bounds the number of stores a prepared query keeps plans for. When it is reached the plan for the
+// store that was least recently evaluated against is dropped, so callers that create a new store for every
+// evaluation do not grow the plans without limit.
+const maxPlansPerQuery = 8
+
+// maxCachedCompilers bounds the number of compilers that prepared queries are cached for by Prepare. When it is
+// reached the compiler that was least recently queried is dropped.
+const maxCachedCompilers = 64
+
+// generation identifies a compiler and the modules it was last compiled with. ast.Compiler.Compile replaces the
+// compiler's Modules map on every call, so the map's address changes whenever the compiler is recompiled, whether
+// through Compile or directly. A queryCache keeps its generation's map alive so that the address cannot be reused.
+type generation uintptr
+
+func generationOf(cmp *ast.Compiler) generation {
+	return generation(reflect.ValueOf(cmp.Modules).Pointer())
+}
+
+// queryCache holds the prepared queries of one compiler generation, keyed by query string
+type queryCache struct {
+	gen     generation
+	modules map[string]*ast.Module
+
+	mtx     sync.Mutex
+	queries map[string]*PreparedQuery
+}
+
+func newQueryCache(cmp *ast.Compiler) *queryCache {
+	return &queryCache{
+		gen:     generationOf(cmp),
+		modules: cmp.Modules,
+		queries: map[string]*PreparedQuery{},
+	}
+}
+
+// prepare returns the prepared query for query, preparing it against cmp if it is not cached yet
+func (c *queryCache) prepare(cmp *ast.Compiler, query string) (*PreparedQuery, error) {
+	c.mtx.Lock()
+	pq, ok := c.queries[query]
+	c.mtx.Unlock()
+	if ok {
+		return pq, nil
+	}
+
+	pq, err := newPreparedQuery(cmp, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if existing, ok := c.queries[query]; ok {
+		return existing, nil
+	}
+	c.queries[query] = pq
+	return pq, nil
+}
+
+// preparedCache holds a queryCache for each of the compiler generations most recently queried through Prepare, most
+// recently used first. Engines keep their own queryCache instead.
+var preparedCache = struct {
+	sync.Mutex
+	entries map[generation]*list.Element
+	lru     *list.List
+}{entries: map[generation]*list.Element{}, lru: list.New()}
+
+// cacheFor returns the queryCache for the current generation of cmp
+func cacheFor(cmp *ast.Compiler) *queryCache {
+	gen := generationOf(cmp)
+
+	preparedCache.Lock()
+	defer preparedCache.Unlock()
+	if el, ok := preparedCache.entries[gen]; ok {
+		preparedCache.lru.MoveToFront(el)
+		return el.Value.(*queryCache)
+	}
+
+	c := newQueryCache(cmp)
+	preparedCache.entries[gen] = preparedCache.lru.PushFront(c)
+	if preparedCache.lru.Len() > maxCachedCompilers {
+		oldest := preparedCache.lru.Back()
+		preparedCache.lru.Remove(oldest)
+		delete(preparedCache.entries, oldest.Value.(*queryCache).gen)
+	}
+	return c
+}
+
+// Prepare parses and plans query against cmp. Prepared queries are cached, so calling Prepare again with the same
+// compiler and query returns the same PreparedQuery until the compiler is recompiled.
+func Prepare(cmp *ast.Compiler, query string) (*PreparedQuery, error) {
+	return cacheFor(cmp).prepare(cmp, query)
+}
+
+// newPreparedQuery parses query and plans it against cmp. Planning compiles the query, so a query that does not
+// compile against cmp is rejected here rather than on every evaluation.
+func newPreparedQuery(cmp *ast.Compiler, query string) (*PreparedQuery, error) {
+	body, err := ast.ParseBody(query)
+	if err != nil {
+		return nil, newQueryError(query, err)
+	}
+
+	pq := newUnplannedQuery(cmp, query, body)
+
+	// will return rego_unsafe_var if junk in query
+	if _, err := pq.plan(context.Background(), nil); err != nil {
+		return nil, newQueryError(query, err)
+	}
+	return pq, nil
+}
+
+// compileQuery parses query and checks that it compiles against cmp without planning it, for queries that are not
+// evaluated, such as those that are only partially evaluated. The query is planned if it is evaluated later.
+func compileQuery(cmp *ast.Compiler, query string) (*PreparedQuery, error) {
+	body, err := ast.ParseBody(query)
+	if err != nil {
+		return nil, newQueryError(query, err)
+	}
+
+	// will return rego_unsafe_var if junk in query
+	if _, err := cmp.QueryCompiler().Compile(body); err != nil {
+		return nil, newQueryError(query, err)
+	}
+	return newUnplannedQuery(cmp, query, body), nil
+}
+
+func newUnplannedQuery(cmp *ast.Compiler, query string, body ast.Body) *PreparedQuery {
+	return &PreparedQuery{
+		cmp:     cmp,
+		query:   query,
+		body:    body,
+		plans:   map[storage.Store]*list.Element{},
+		planLRU: list.New(),
+	}
+}
+
+// plan returns the query planned against store, planning it if needed
+func (pq *PreparedQuery) plan(ctx context.Context, store storage.Store) (rego.PreparedEvalQuery, error) {
+	// stores of uncomparable types cannot be used as map keys, so their plans are not kept
+	cacheable := store == nil || reflect.TypeOf(store).Comparable()
+	if cacheable {
+		pq.mtx.Lock()
+		el, ok := pq.plans[store]
+		if ok {
+			pq.planLRU.MoveToFront(el)
+		}
+		pq.mtx.Unlock()
+		if ok {
+			return el.Value.(*storePlan).plan, nil
+		}
+	}
+
+	args := []func(r *rego.Rego){
+		rego.ParsedQuery(pq.body),
+		rego.Compiler(pq.cmp),
+	}
+
+	if store != nil {
+		args = append(args, rego.Store(store))
+	}
+
+	p, err := rego.New(args...).PrepareForEval(ctx)
+	if err != nil {
+		return p, err
+	}
+
+	if cacheable {
+		pq.mtx.Lock()
+		defer pq.mtx.Unlock()
+		if el, ok := pq.plans[store]; ok {
+			pq.planLRU.MoveToFront(el)
+			return el.Value.(*storePlan).plan, nil
+		}
+		pq.plans[store] = pq.planLRU.PushFront(&storePlan{store: store, plan: p})
+		if pq.planLRU.Len() > maxPlansPerQuery {
+			oldest := pq.planLRU.Back()
+			pq.planLRU.Remove(oldest)
+			delete(pq.plans, oldest.Value.(*storePlan).store)
+		}
+	}
+	return p, nil
+}
+
+// Eval evaluates the prepared query with the given input and store. It behaves like QueryContext. input is converted
+// with InputValue; if it cannot be converted an InputErr is returned.
+func (pq *PreparedQuery) Eval(ctx context.Context, input interface{}, store *storage.Store) (rego.ResultSet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, newContextError(pq.query, err)
+	}
+
+	value, err := InputValue(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var s storage.Store
+	if store != nil {
+		s = *store
+	}
+
+	p, err := pq.plan(ctx, s)
+	if err != nil {
+		return nil, newQueryError(pq.query, err)
+	}
+
+	rs, err := p.Eval(ctx, rego.EvalParsedInput(value))
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, newContextError(pq.query, ctxErr)
+		}
+		return nil, newQueryError(pq.query, err)
+	}
+
+	return rs, nil
+}
+
+// String returns the query the PreparedQuery was created from.
+func (pq *PreparedQuery) String() string {
+	return pq.query
+}
+
+// InvalidatePrepared drops every prepared query cached by Prepare for the current generation of cmp. Recompiling cmp
+// already stops its cached queries from being used; InvalidatePrepared only needs to be called directly when a
+// compiler is discarded, so that its cached queries can be garbage collected before they are evicted.
+func InvalidatePrepared(cmp *ast.Compiler) {
+	gen := generationOf(cmp)
+
+	preparedCache.Lock()
+	defer preparedCache.Unlock()
+	if el, ok := preparedCache.entries[gen]; ok {
+		preparedCache.lru.Remove(el)
+		delete(preparedCache.entries, gen)
+	}
+}
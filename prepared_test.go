@@ -0,0 +1,254 @@
+package rego
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+func TestPrepareCached(t *testing.T) {
+	policy := `
+	package test
+	eval = x { x := input.a }
+	`
+	cmp := setup(policy)
+	pq1, err := Prepare(cmp, "data.test.eval")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	pq2, err := Prepare(cmp, "data.test.eval")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if pq1 != pq2 {
+		t.Fatalf("expected the same prepared query to be returned")
+	}
+
+	rs, err := pq1.Eval(context.Background(), map[string]interface{}{"a": 7}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(rs) != 1 {
+		t.Fatalf("expected one result, got %v", rs)
+	}
+	validate(t, rs[0].Expressions[0].Value, 7)
+}
+
+func TestPrepareInvalidatedByCompile(t *testing.T) {
+	cmp := NewCompiler()
+	mod, err := ParseBytes("test", []byte("package test\neval = 1"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := Compile(cmp, map[string]*ast.Module{"test": mod}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	pq1, err := Prepare(cmp, "data.test.eval")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := Compile(cmp, map[string]*ast.Module{"test": mod}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	pq2, err := Prepare(cmp, "data.test.eval")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if pq1 == pq2 {
+		t.Fatalf("expected prepared query to be invalidated by Compile")
+	}
+}
+
+func TestPrepareBadQuery(t *testing.T) {
+	cmp := setup("package test\neval = 1")
+	_, err := Prepare(cmp, "x = y")
+	if err == nil {
+		t.Fatalf("did not catch unsafe query")
+	}
+	if !IsEvalErr(err) {
+		t.Fatalf("incorrect error type")
+	}
+}
+
+func TestPrepareConcurrent(t *testing.T) {
+	policy := `
+	package test
+	eval = x { x := input.a * 2 }
+	`
+	cmp := setup(policy)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := QueryRule(cmp, "test", "eval", map[string]interface{}{"a": i}, nil)
+			if err != nil {
+				t.Errorf(err.Error())
+				return
+			}
+			ok, err := areEqualJson(res, i*2)
+			if err != nil || !ok {
+				t.Errorf("got %v, expected %v", res, i*2)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPrepareInvalidatedByDirectCompile(t *testing.T) {
+	cmp := compileModules([]string{"package test\neval = 1"})
+	res, err := QueryRule(cmp, "test", "eval", nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 1)
+
+	// recompiling through the OPA API rather than Compile must not leave stale queries behind
+	cmp.Compile(map[string]*ast.Module{"testMod0": ast.MustParseModule("package test\nother = 2")})
+	if cmp.Failed() {
+		t.Fatalf(cmp.Errors.Error())
+	}
+	if _, err := QueryRule(cmp, "test", "eval", nil, nil); !IsUndefined(err) {
+		t.Fatalf("expected stale query to be dropped, got %v", err)
+	}
+}
+
+func TestPrepareCacheEviction(t *testing.T) {
+	cmp := setup("package test\neval = 1")
+	pq, err := Prepare(cmp, "data.test.eval")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// compilers that keep being queried survive others filling the cache
+	for i := 0; i < 2*maxCachedCompilers; i++ {
+		if _, err := Prepare(setup("package test\neval = 2"), "data.test.eval"); err != nil {
+			t.Fatalf(err.Error())
+		}
+		again, err := Prepare(cmp, "data.test.eval")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if again != pq {
+			t.Fatalf("recently used compiler evicted after %v others", i+1)
+		}
+	}
+
+	preparedCache.Lock()
+	n := preparedCache.lru.Len()
+	preparedCache.Unlock()
+	if n > maxCachedCompilers {
+		t.Fatalf("cache holds %v compilers, expected at most %v", n, maxCachedCompilers)
+	}
+}
+
+func TestPrepareStores(t *testing.T) {
+	cmp := setup("package test\neval = data.x")
+	pq, err := Prepare(cmp, "data.test.eval")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := 0; i < 2*maxPlansPerQuery; i++ {
+		store := inmem.NewFromObject(map[string]interface{}{"x": i})
+		rs, err := pq.Eval(context.Background(), nil, &store)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		validate(t, rs[0].Expressions[0].Value, i)
+	}
+	if len(pq.plans) > maxPlansPerQuery {
+		t.Fatalf("prepared query holds %v plans, expected at most %v", len(pq.plans), maxPlansPerQuery)
+	}
+}
+
+func TestPrepareStoresEviction(t *testing.T) {
+	cmp := setup("package test\neval = data.x")
+	pq, err := Prepare(cmp, "data.test.eval")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// a store that keeps being evaluated against keeps its plan while other stores fill the plans
+	store := inmem.NewFromObject(map[string]interface{}{"x": "kept"})
+	for i := 0; i < 2*maxPlansPerQuery; i++ {
+		other := inmem.NewFromObject(map[string]interface{}{"x": i})
+		if _, err := pq.Eval(context.Background(), nil, &other); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, err := pq.Eval(context.Background(), nil, &store); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, ok := pq.plans[store]; !ok {
+			t.Fatalf("plan of recently used store evicted after %v others", i+1)
+		}
+	}
+}
+
+func TestCompileQueryUnplanned(t *testing.T) {
+	cmp := setup("package test\neval = input.x")
+	pq, err := compileQuery(cmp, "data.test.eval")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(pq.plans) != 0 {
+		t.Fatalf("expected query not to be planned, got %v plans", len(pq.plans))
+	}
+
+	// a query that is compiled only is planned when it is evaluated
+	rs, err := pq.Eval(context.Background(), map[string]interface{}{"x": 1}, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, rs[0].Expressions[0].Value, 1)
+
+	if _, err := compileQuery(cmp, "x"); !IsEvalErr(err) {
+		t.Fatalf("expected eval error for unsafe query, got %v", err)
+	}
+}
+
+const benchmarkQueryPolicy = `
+	package test
+
+	allow { input.user == data.admins[_] }
+	allow { input.resource.owner == input.user }
+`
+
+func benchmarkQueryInput() map[string]interface{} {
+	return map[string]interface{}{"user": "bob", "resource": map[string]interface{}{"owner": "bob"}}
+}
+
+// BenchmarkQueryPrepared measures evaluating a query that is parsed and planned once
+func BenchmarkQueryPrepared(b *testing.B) {
+	cmp := setup(benchmarkQueryPolicy)
+	store := inmem.NewFromObject(map[string]interface{}{"admins": []interface{}{"alice"}})
+	input := benchmarkQueryInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := QueryRule(cmp, "test", "allow", input, &store); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkQueryUnprepared measures evaluating the same query through OPA without preparing it, which is what Query
+// did before queries were prepared
+func BenchmarkQueryUnprepared(b *testing.B) {
+	cmp := setup(benchmarkQueryPolicy)
+	store := inmem.NewFromObject(map[string]interface{}{"admins": []interface{}{"alice"}})
+	input := benchmarkQueryInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body, err := ast.ParseBody("data.test.allow")
+		if err != nil {
+			b.Fatal(err)
+		}
+		r := rego.New(rego.ParsedQuery(body), rego.Compiler(cmp), rego.Store(store), rego.Input(input))
+		if _, err := r.Eval(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -10,33 +10,42 @@ import (
 )
 
 // Query returns a ResultSet for the given query run on the given compiler
-func Query(cmp *ast.Compiler, query string, inputs map[string]interface{}, store *storage.Store) (rego.ResultSet, error) {
-	args := []func(r *rego.Rego){
-		rego.Query(query),
-		rego.Compiler(cmp),
-		rego.Input(inputs),
-	}
+func Query(cmp *ast.Compiler, query string, input interface{}, store *storage.Store) (rego.ResultSet, error) {
+	return QueryContext(context.Background(), cmp, query, input, store)
+}
 
-	if store != nil {
-		args = append(args, rego.Store(*store))
+// QueryContext is like Query but evaluates under ctx. If ctx is cancelled or its deadline passes before evaluation
+// completes, a CancelErr is returned.
+func QueryContext(ctx context.Context, cmp *ast.Compiler, query string, input interface{}, store *storage.Store) (rego.ResultSet, error) {
+	pq, err := Prepare(cmp, query)
+	if err != nil {
+		return nil, err
 	}
+	return pq.Eval(ctx, input, store)
+}
 
-	rg := rego.New(args...)
+// QueryRule makes a query and returns a *single* value of any type that is produced by evaluation. If multiple objects
+// are produced upon evaluation or no object is produced, error != nil.
+func QueryRule(cmp *ast.Compiler, pkg, rule string, input interface{}, store *storage.Store) (interface{}, error) {
+	return QueryRuleContext(context.Background(), cmp, pkg, rule, input, store)
+}
 
-	// will return rego_unsafe_var if junk in query
-	rs, err := rg.Eval(context.Background())
+// QueryRuleContext is like QueryRule but evaluates under ctx.
+func QueryRuleContext(ctx context.Context, cmp *ast.Compiler, pkg, rule string, input interface{}, store *storage.Store) (interface{}, error) {
+	pq, err := Prepare(cmp, ruleQuery(pkg, rule))
 	if err != nil {
-		return nil, NewEvalError(query + ": " + err.Error())
+		return nil, err
 	}
+	return pq.evalRule(ctx, rule, input, store)
+}
 
-	return rs, nil
+func ruleQuery(pkg, rule string) string {
+	return fmt.Sprintf("data.%v.%v", pkg, rule)
 }
 
-// QueryRule makes a query and returns a *single* value of any type that is produced by evaluation. If multiple objects
-// are produced upon evaluation or no object is produced, error != nil.
-func QueryRule(cmp *ast.Compiler, pkg, rule string, inputs map[string]interface{}, store *storage.Store) (interface{}, error) {
-	q := fmt.Sprintf("data.%v.%v", pkg, rule)
-	rs, err := Query(cmp, q, inputs, store)
+// evalRule evaluates a query prepared by ruleQuery and returns the single value it produces
+func (pq *PreparedQuery) evalRule(ctx context.Context, rule string, input interface{}, store *storage.Store) (interface{}, error) {
+	rs, err := pq.Eval(ctx, input, store)
 	if err != nil {
 		return nil, err
 	}
@@ -51,21 +60,8 @@ func QueryRule(cmp *ast.Compiler, pkg, rule string, inputs map[string]interface{
 	}
 
 	if len(rs[0].Expressions) == 0 {
-		err = errors.Wrap(fmt.Errorf("no values produced by this rule"), rule)
+		return nil, errors.Wrap(fmt.Errorf("no values produced by this rule"), rule)
 	}
 
 	return rs[0].Expressions[0].Value, err
-}
-
-// IsUndefined returns true if the given error is an UndefinedErr
-func IsUndefined(err error) bool {
-	_, ok := err.(*UndefinedErr)
-	return ok
-}
-
-// IsEvalErr returns true if the given error is an EvalErr
-func IsEvalErr(err error) bool {
-	_, ok := err.(*EvalErr)
-	return ok
-}
-
+}
\ No newline at end of file
@@ -3,6 +3,11 @@ package rego
 import (
 	"testing"
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown"
+	"context"
+	"errors"
+	"fmt"
+	"time"
 )
 
 func setup(policy string) (*ast.Compiler) {
@@ -117,4 +122,160 @@ func TestQueryRuleEvalErr(t *testing.T)  {
 	if !IsEvalErr(err) {
 		t.Fatalf("incorrect error type")
 	}
+}
+
+func TestQueryRuleContextCancelled(t *testing.T) {
+	policy := `
+	package test
+	eval { true }
+	`
+	cmp := setup(policy)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := QueryRuleContext(ctx, cmp, "test", "eval", nil, nil)
+	if err == nil {
+		t.Fatalf("did not catch cancellation")
+	}
+	if !IsCancelled(err) {
+		t.Fatalf("incorrect error type")
+	}
+}
+
+func TestQueryRuleContextDeadline(t *testing.T) {
+	policy := `
+	package test
+	eval { true }
+	`
+	cmp := setup(policy)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err := QueryRuleContext(ctx, cmp, "test", "eval", nil, nil)
+	if !IsCancelled(err) {
+		t.Fatalf("expected cancel error, got %v", err)
+	}
+}
+
+func TestQueryRuleContextCancelledDuringEval(t *testing.T) {
+	policy := `
+	package test
+	eval { x := input.values[_]; y := input.values[_]; z := input.values[_]; x + y + z < 0 }
+	`
+	cmp := setup(policy)
+	values := make([]interface{}, 1000)
+	for i := range values {
+		values[i] = i
+	}
+
+	// the deadline passes while OPA is iterating, not before evaluation starts
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := QueryRuleContext(ctx, cmp, "test", "eval", map[string]interface{}{"values": values}, nil)
+	if !IsCancelled(err) {
+		t.Fatalf("expected cancel error, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline to be the cause, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("evaluation was not stopped by the deadline, took %v", elapsed)
+	}
+}
+
+func TestQueryRuleContextSingle(t *testing.T) {
+	policy := `
+	package test
+	eval = 5 { true }
+	`
+	cmp := setup(policy)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	res, err := QueryRuleContext(ctx, cmp, "test", "eval", nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 5)
+}
+
+func TestQueryEvalErrDetails(t *testing.T) {
+	cmp := setup(`
+	package test
+	eval { true }
+	`)
+	_, err := Query(cmp, "x = y", nil, nil)
+	if !IsEvalErr(err) {
+		t.Fatalf("incorrect error type")
+	}
+	e := err.(*EvalErr)
+	if e.Query != "x = y" {
+		t.Fatalf("expected query to be recorded, got %v", e.Query)
+	}
+	if e.Code != "rego_unsafe_var_error" {
+		t.Fatalf("expected rego_unsafe_var_error, got %v", e.Code)
+	}
+	if e.Location == nil || e.Location.Row != 1 {
+		t.Fatalf("unexpected location %v", e.Location)
+	}
+}
+
+
+func TestErrorsWrapped(t *testing.T) {
+	cmp := setup(`
+	package test
+	eval { false }
+	bad { http.send({}) }
+	`)
+
+	_, err := QueryRule(cmp, "test", "eval", nil, nil)
+	wrapped := fmt.Errorf("handler: %w", err)
+	if !IsUndefined(wrapped) || !errors.Is(wrapped, &UndefinedErr{}) {
+		t.Fatalf("wrapped undefined error not detected")
+	}
+
+	_, err = QueryRule(cmp, "test", "bad", nil, nil)
+	wrapped = fmt.Errorf("handler: %w", err)
+	if !IsEvalErr(wrapped) || !errors.Is(wrapped, &EvalErr{}) {
+		t.Fatalf("wrapped eval error not detected")
+	}
+	var evalErr *EvalErr
+	if !errors.As(wrapped, &evalErr) || evalErr.Cause == nil {
+		t.Fatalf("expected eval error to carry its cause")
+	}
+	var topdownErr *topdown.Error
+	if !errors.As(wrapped, &topdownErr) {
+		t.Fatalf("expected cause to be the OPA error, got %T", evalErr.Cause)
+	}
+}
+
+func TestCancelErrCause(t *testing.T) {
+	cmp := setup(`
+	package test
+	eval { true }
+	`)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err := QueryRuleContext(ctx, cmp, "test", "eval", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline to be the cause, got %v", err)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Fatalf("timeout reported as cancellation")
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	errs := new(Errors)
+	errs.Add(fmt.Errorf("unrelated"))
+	errs.Add(NewUndefinedError("x: query undefined"))
+	var err error = errs
+
+	var undefined *UndefinedErr
+	if !errors.As(err, &undefined) {
+		t.Fatalf("expected errors.As to find the member of Errors")
+	}
+	if !IsUndefined(err) || IsEvalErr(err) {
+		t.Fatalf("members of Errors misclassified")
+	}
 }
\ No newline at end of file
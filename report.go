@@ -0,0 +1,244 @@
+package rego
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Categories of errors as reported by MarshalErrorsJSON
+const (
+	CategoryParse     = "parse"
+	CategoryCompile   = "compile"
+	CategoryEval      = "eval"
+	CategoryUndefined = "undefined"
+	CategoryCancel    = "cancel"
+	CategoryEnvelope  = "envelope"
+	CategoryDecode    = "decode"
+	CategoryDecision  = "decision"
+	CategoryInput     = "input"
+	CategorySQL       = "sql"
+	CategoryOther     = "other"
+)
+
+// ErrorReport is the structured form of a single error, as rendered by MarshalErrorsJSON
+type ErrorReport struct {
+	Category string    `json:"category"`
+	Code     string    `json:"code,omitempty"`
+	Message  string    `json:"message"`
+	Location *Location `json:"location,omitempty"`
+	Rule     string    `json:"rule,omitempty"`
+	Query    string    `json:"query,omitempty"`
+	// Path is the position in a query result of the value that could not be decoded
+	Path string `json:"path,omitempty"`
+	// Expr is the expression that could not be translated to SQL
+	Expr string `json:"expr,omitempty"`
+}
+
+// ReportErrors flattens err into one ErrorReport per error. *Errors, also when wrapped, and the ast.Errors returned
+// by OPA are expanded into their members.
+func ReportErrors(err error) []ErrorReport {
+	reports := make([]ErrorReport, 0)
+	if err == nil {
+		return reports
+	}
+	var errs *Errors
+	if astErrs, ok := err.(ast.Errors); ok {
+		for _, member := range astErrs {
+			reports = append(reports, ReportErrors(member)...)
+		}
+	} else if errors.As(err, &errs) {
+		for _, member := range *errs {
+			reports = append(reports, ReportErrors(member)...)
+		}
+	} else {
+		reports = append(reports, newErrorReport(err))
+	}
+	return reports
+}
+
+// newErrorReport categorizes err by the first error of this package found in its chain, so errors wrapped with
+// fmt.Errorf or pkg/errors are reported like the errors they wrap. The message of a wrapped error includes the
+// context added by the wrappers.
+func newErrorReport(err error) ErrorReport {
+	var (
+		parseErr      *ParseErr
+		compileErr    *CompileErr
+		evalErr       *EvalErr
+		undefinedErr  *UndefinedErr
+		cancelErr     *CancelErr
+		envelopeErr   *EnvelopeErr
+		decodeErr     *DecodeErr
+		nonBooleanErr *NonBooleanErr
+		inputErr      *InputErr
+		sqlErr        *SQLErr
+		astErr        *ast.Error
+	)
+
+	var report ErrorReport
+	var cause error
+	// ParseErr, CompileErr and EvalErr wrap the OPA errors they were made from, so they are looked for first
+	switch {
+	case errors.As(err, &parseErr):
+		report = ErrorReport{Category: CategoryParse, Code: parseErr.Code, Message: parseErr.Message,
+			Location: parseErr.Location}
+		cause = parseErr
+	case errors.As(err, &compileErr):
+		report = ErrorReport{Category: CategoryCompile, Code: compileErr.Code, Message: compileErr.Message,
+			Location: compileErr.Location, Rule: compileErr.Rule}
+		cause = compileErr
+	case errors.As(err, &evalErr):
+		report = ErrorReport{Category: CategoryEval, Code: evalErr.Code, Message: evalErr.Message,
+			Location: evalErr.Location, Query: evalErr.Query}
+		cause = evalErr
+	case errors.As(err, &undefinedErr):
+		report = ErrorReport{Category: CategoryUndefined, Message: undefinedErr.Message}
+		cause = undefinedErr
+	case errors.As(err, &cancelErr):
+		report = ErrorReport{Category: CategoryCancel, Message: cancelErr.Message}
+		cause = cancelErr
+	case errors.As(err, &envelopeErr):
+		report = ErrorReport{Category: CategoryEnvelope, Message: envelopeErr.Message}
+		cause = envelopeErr
+	case errors.As(err, &decodeErr):
+		report = ErrorReport{Category: CategoryDecode, Message: decodeErr.Message, Path: decodeErr.Path}
+		cause = decodeErr
+	case errors.As(err, &nonBooleanErr):
+		report = ErrorReport{Category: CategoryDecision, Message: nonBooleanErr.Message}
+		cause = nonBooleanErr
+	case errors.As(err, &inputErr):
+		report = ErrorReport{Category: CategoryInput, Message: inputErr.Message}
+		cause = inputErr
+	case errors.As(err, &sqlErr):
+		report = ErrorReport{Category: CategorySQL, Message: sqlErr.Message, Expr: sqlErr.Expr}
+		cause = sqlErr
+	case errors.As(err, &astErr):
+		report = ErrorReport{Category: CategoryCompile, Code: astErr.Code, Message: astErr.Message,
+			Location: newLocation(astErr.Location)}
+		cause = astErr
+	default:
+		return ErrorReport{Category: CategoryOther, Message: err.Error()}
+	}
+
+	if cause != err {
+		report.Message = err.Error()
+	}
+	return report
+}
+
+// MarshalJSON renders the errors as a JSON array of ErrorReport objects.
+func (e *Errors) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ReportErrors(e))
+}
+
+// MarshalErrorsJSON renders err, which may be an *Errors, as a JSON array of ErrorReport objects.
+func MarshalErrorsJSON(err error) ([]byte, error) {
+	return json.Marshal(ReportErrors(err))
+}
+
+// sarifLog and the types below are the subset of SARIF 2.1.0 written by MarshalErrorsSARIF
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// MarshalErrorsSARIF renders err, which may be an *Errors, as a SARIF 2.1.0 log so that code review tools can
+// annotate the offending lines. Each error code becomes a SARIF rule; errors without a code are reported under their
+// category.
+func MarshalErrorsSARIF(err error) ([]byte, error) {
+	reports := ReportErrors(err)
+
+	ruleIDs := make(map[string]bool)
+	results := make([]sarifResult, 0, len(reports))
+	for _, report := range reports {
+		id := report.Code
+		if id == "" {
+			id = report.Category
+		}
+		ruleIDs[id] = true
+
+		result := sarifResult{
+			RuleID:  id,
+			Level:   "error",
+			Message: sarifMessage{Text: report.Message},
+		}
+		if loc := report.Location; loc != nil && loc.File != "" {
+			physical := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: loc.File}}
+			if loc.Row > 0 {
+				physical.Region = &sarifRegion{StartLine: loc.Row, StartColumn: loc.Col}
+			}
+			result.Locations = []sarifLocation{{PhysicalLocation: physical}}
+		}
+		results = append(results, result)
+	}
+
+	rules := make([]sarifRule, 0, len(ruleIDs))
+	for id := range ruleIDs {
+		rules = append(rules, sarifRule{ID: id})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	return json.Marshal(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "rego",
+				InformationURI: "https://www.openpolicyagent.org",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	})
+}
@@ -0,0 +1,136 @@
+package rego
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestReportCompileErrors(t *testing.T) {
+	modules := map[string]*ast.Module{
+		"a.rego": mustParse(t, "a.rego", "package a\nx = y"),
+	}
+	err := Compile(NewCompiler(), modules)
+	if err == nil {
+		t.Fatalf("did not catch compilation error")
+	}
+
+	data, err := MarshalErrorsJSON(err)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var reports []ErrorReport
+	if err := json.Unmarshal(data, &reports); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(reports) == 0 {
+		t.Fatalf("expected reports, got none")
+	}
+	report := reports[0]
+	if report.Category != CategoryCompile || report.Code == "" || report.Rule != "x" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Location == nil || report.Location.File != "a.rego" || report.Location.Row != 2 {
+		t.Fatalf("unexpected location %+v", report.Location)
+	}
+}
+
+func TestReportParseErrorsSARIF(t *testing.T) {
+	_, err := ParseBytes("bad.rego", []byte("package a\nx = {"))
+	if err == nil {
+		t.Fatalf("did not catch parse error")
+	}
+
+	data, err := MarshalErrorsSARIF(err)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected SARIF log %s", data)
+	}
+	run := log.Runs[0]
+	if len(run.Results) == 0 || len(run.Tool.Driver.Rules) == 0 {
+		t.Fatalf("expected results and rules, got %s", data)
+	}
+	result := run.Results[0]
+	if result.RuleID != ast.ParseErr || result.Level != "error" || len(result.Locations) != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	physical := result.Locations[0].PhysicalLocation
+	if physical.ArtifactLocation.URI != "bad.rego" || physical.Region == nil || physical.Region.StartLine < 1 {
+		t.Fatalf("unexpected location %+v", physical)
+	}
+}
+
+func TestReportOtherErrors(t *testing.T) {
+	errs := new(Errors)
+	errs.Add(NewUndefinedError("undefined"))
+	errs.Add(NewEnvelopeError("missing envelope"))
+
+	data, err := json.Marshal(errs)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var reports []ErrorReport
+	if err := json.Unmarshal(data, &reports); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(reports) != 2 || reports[0].Category != CategoryUndefined || reports[1].Category != CategoryEnvelope {
+		t.Fatalf("unexpected reports %s", data)
+	}
+	if reports[0].Location != nil {
+		t.Fatalf("expected no location, got %+v", reports[0].Location)
+	}
+}
+
+func TestReportWrappedErrors(t *testing.T) {
+	_, parseErr := ParseBytes("bad.rego", []byte("package a\nx = {"))
+	_, formatErr := FormatModules(map[string]*ast.Module{"b.rego": {}})
+
+	errs := new(Errors)
+	errs.Add(fmt.Errorf("loading policies: %w", (*parseErr.(*Errors))[0]))
+	errs.Add(pkgerrors.Wrap(NewUndefinedError("x: query undefined"), "handler"))
+	errs.Add(formatErr)
+	errs.Add(fmt.Errorf("decoding: %w", NewDecodeError("data.a.x[1]", "expected string, got number")))
+
+	reports := ReportErrors(errs)
+	if len(reports) != 4 {
+		t.Fatalf("expected 4 reports, got %+v", reports)
+	}
+	if reports[0].Category != CategoryParse || reports[0].Location == nil || reports[0].Location.File != "bad.rego" {
+		t.Fatalf("wrapped parse error misreported: %+v", reports[0])
+	}
+	if !strings.HasPrefix(reports[0].Message, "loading policies: ") {
+		t.Fatalf("expected the message to keep the context of the wrapper, got %v", reports[0].Message)
+	}
+	if reports[1].Category != CategoryUndefined {
+		t.Fatalf("wrapped undefined error misreported: %+v", reports[1])
+	}
+	if reports[2].Category != CategoryOther || !strings.HasPrefix(reports[2].Message, "b.rego: ") {
+		t.Fatalf("format error misreported: %+v", reports[2])
+	}
+	if reports[3].Category != CategoryDecode || reports[3].Path != "data.a.x[1]" || reports[3].Query != "" {
+		t.Fatalf("decode error misreported: %+v", reports[3])
+	}
+}
+
+func TestReportWrappedErrorsExpanded(t *testing.T) {
+	_, err := ParseBytes("bad.rego", []byte("package a\nx = {\ny = ["))
+	reports := ReportErrors(fmt.Errorf("loading policies: %w", err))
+	if len(reports) == 0 {
+		t.Fatalf("expected reports, got none")
+	}
+	for _, report := range reports {
+		if report.Category != CategoryParse {
+			t.Fatalf("expected the members of the wrapped *Errors to be reported, got %+v", reports)
+		}
+	}
+}
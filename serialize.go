@@ -0,0 +1,69 @@
+package rego
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// modulesVersion is the version of the format written by SerializeModules. It must be incremented whenever that
+// format changes incompatibly.
+const modulesVersion = 1
+
+// serializedModules is the document written by SerializeModules
+type serializedModules struct {
+	Version int                        `json:"version"`
+	Modules map[string]json.RawMessage `json:"modules"`
+}
+
+// SerializeModules converts a whole set of modules into a single JSON document, preserving the keys of modules. As
+// with SerializeModuleJson, locations are not stored, so the modules should be compiled and checked for correctness
+// before they are serialized.
+func SerializeModules(modules map[string]*ast.Module) ([]byte, error) {
+	doc := serializedModules{
+		Version: modulesVersion,
+		Modules: make(map[string]json.RawMessage, len(modules)),
+	}
+	for name, module := range modules {
+		data, err := SerializeModuleJson(module)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		doc.Modules[name] = data
+	}
+	return json.Marshal(doc)
+}
+
+// DeserializeModules reads a set of modules written by SerializeModules. If cmp is not nil the modules are also
+// compiled with it, and any compilation errors are returned alongside the modules.
+func DeserializeModules(data []byte, cmp *ast.Compiler) (map[string]*ast.Module, error) {
+	var doc serializedModules
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != modulesVersion {
+		return nil, fmt.Errorf("unsupported module set version %v, expected %v", doc.Version, modulesVersion)
+	}
+
+	errs := new(Errors)
+	modules := make(map[string]*ast.Module, len(doc.Modules))
+	for name, raw := range doc.Modules {
+		module, err := DeserializeModuleJson(raw)
+		if err != nil {
+			errs.Add(fmt.Errorf("%v: %w", name, err))
+			continue
+		}
+		modules[name] = module
+	}
+	if err := errs.NilIfEmpty(); err != nil {
+		return nil, err
+	}
+
+	if cmp != nil {
+		if err := Compile(cmp, modules); err != nil {
+			return modules, err
+		}
+	}
+	return modules, nil
+}
@@ -0,0 +1,149 @@
+package rego
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestModulesSerialization(t *testing.T) {
+	modules := map[string]*ast.Module{
+		"a.rego": mustParse(t, "a.rego", `
+		package a
+		x = y { y := data.b.y + 1 }
+		`),
+		"b.rego": mustParse(t, "b.rego", `
+		package b
+		y = 2
+		`),
+	}
+
+	data, err := SerializeModules(modules)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cmp := NewCompiler()
+	result, err := DeserializeModules(data, cmp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(result) != 2 {
+		t.Fatalf("expected 2 modules, got %v", len(result))
+	}
+	for name := range modules {
+		if _, ok := result[name]; !ok {
+			t.Fatalf("module %v missing after deserialization", name)
+		}
+	}
+
+	res, err := QueryRule(cmp, "a", "x", nil, nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	validate(t, res, 3)
+}
+
+func TestModulesDeserializationCompileFailure(t *testing.T) {
+	modules := map[string]*ast.Module{
+		"a.rego": mustParse(t, "a.rego", "package a\nx = y"),
+	}
+	data, err := SerializeModules(modules)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := DeserializeModules(data, NewCompiler())
+	if err == nil {
+		t.Fatalf("did not catch compilation error")
+	}
+	if len(result) != 1 {
+		t.Fatalf("expected modules to be returned alongside compilation errors")
+	}
+}
+
+func TestModulesDeserializationVersion(t *testing.T) {
+	_, err := DeserializeModules([]byte(`{"version": 99, "modules": {}}`), nil)
+	if err == nil || !strings.Contains(err.Error(), "unsupported module set version") {
+		t.Fatalf("expected version error, got %v", err)
+	}
+}
+
+func TestModuleSerializationWithLocations(t *testing.T) {
+	mod := mustParse(t, "policy.rego", `package test
+
+allow {
+	input.user == "admin"
+}
+
+broken = x {
+	y := 1
+}
+`)
+
+	data, err := SerializeModuleJsonWithLocations(mod)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := DeserializeModuleJsonWithLocations(data)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if result.Rules[1].Location == nil || result.Rules[1].Location.Row != 7 {
+		t.Fatalf("expected rule location to be restored, got %v", result.Rules[1].Location)
+	}
+
+	err = Compile(NewCompiler(), map[string]*ast.Module{"policy.rego": result})
+	if err == nil {
+		t.Fatalf("did not catch compilation error")
+	}
+	if !strings.Contains(err.Error(), "policy.rego:7") {
+		t.Fatalf("expected error to point to original source, got %v", err)
+	}
+}
+
+func TestModuleSerializationWithLocationsCompressed(t *testing.T) {
+	mod := mustParse(t, "policy.rego", "package test\n\nallow { input.admin }\n")
+	data, err := SerializeModuleJsonWithLocations(mod)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	compressed, err := Compress(data, CompressionGzip)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	result, err := DeserializeModuleJsonWithLocations(compressed)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if result.Rules[0].Location == nil || result.Rules[0].Location.Row != 3 {
+		t.Fatalf("expected rule location to be restored, got %v", result.Rules[0].Location)
+	}
+}
+
+func TestModuleSerializationWithLocationsMismatch(t *testing.T) {
+	mod := mustParse(t, "policy.rego", "package test\n\nallow { input.admin }\n")
+	data, err := SerializeModuleJsonWithLocations(mod)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var doc locatedModule
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// the package is followed by the terms of its path
+	doc.Locations[0], doc.Locations[1] = doc.Locations[1], doc.Locations[0]
+	swapped, _ := json.Marshal(doc)
+	if _, err := DeserializeModuleJsonWithLocations(swapped); err == nil {
+		t.Fatalf("did not catch locations out of order")
+	}
+
+	doc.Locations = doc.Locations[:len(doc.Locations)-1]
+	truncated, _ := json.Marshal(doc)
+	if _, err := DeserializeModuleJsonWithLocations(truncated); err == nil {
+		t.Fatalf("did not catch missing location")
+	}
+}
@@ -0,0 +1,370 @@
+package rego
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// Table declares a SQL table that residual queries can be translated against
+type Table struct {
+	// Name is the name of the table in SQL. It is used to qualify column names.
+	Name string
+	// Ref is the unknown that rows of the table are bound to in Rego, e.g. input.resource
+	Ref string
+	// Columns maps fields of Ref to the names of their columns, e.g. "owner" to "owner_id". Fields that are not
+	// declared cannot be translated. Table and column names are written to SQL as they are, without quoting.
+	Columns map[string]string
+}
+
+// Placeholder selects how parameters are written in SQL
+type Placeholder int
+
+const (
+	// PlaceholderQuestion writes every parameter as ?, as used by MySQL and SQLite
+	PlaceholderQuestion Placeholder = iota
+	// PlaceholderDollar writes parameters as $1, $2 and so on, as used by PostgreSQL
+	PlaceholderDollar
+)
+
+// SQLWhere is a parameterized SQL condition. Clause holds placeholders for Args, in order.
+type SQLWhere struct {
+	Clause string
+	Args   []interface{}
+}
+
+// SQLErr represents error caused by a residual query that cannot be translated to SQL
+type SQLErr struct {
+	Message string
+	// Expr is the expression that could not be translated, if any
+	Expr string
+}
+
+// NewSQLError creates a new SQLErr with the given message
+func NewSQLError(msg string) *SQLErr {
+	return &SQLErr{Message: msg}
+}
+
+func (e *SQLErr) Error() string {
+	if e.Expr != "" {
+		return e.Expr + ": " + e.Message
+	}
+	return e.Message
+}
+
+// IsSQLErr returns true if the given error is or wraps a SQLErr
+func IsSQLErr(err error) bool {
+	var e *SQLErr
+	return errors.As(err, &e)
+}
+
+// PartialSQL partially evaluates query with the Ref of every table unknown and translates the residual into a SQL
+// condition with TranslateSQL, so that rows can be filtered by policy in the database.
+func PartialSQL(cmp *ast.Compiler, query string, tables []Table, input interface{}, store *storage.Store, placeholder Placeholder) (*SQLWhere, error) {
+	return PartialSQLContext(context.Background(), cmp, query, tables, input, store, placeholder)
+}
+
+// PartialSQLContext is like PartialSQL but evaluates under ctx.
+func PartialSQLContext(ctx context.Context, cmp *ast.Compiler, query string, tables []Table, input interface{}, store *storage.Store, placeholder Placeholder) (*SQLWhere, error) {
+	unknowns := make([]string, len(tables))
+	for i, t := range tables {
+		unknowns[i] = t.Ref
+	}
+	residual, err := PartialQueryContext(ctx, cmp, query, unknowns, input, store)
+	if err != nil {
+		return nil, err
+	}
+	return TranslateSQL(residual, tables, placeholder)
+}
+
+// TranslateSQL translates residual into a SQL condition over tables. The residual queries are joined with OR and the
+// expressions of each query with AND. Expressions may compare a column to a scalar or another column with =, ==,
+// !=, <, <=, > or >=, test a boolean column, test membership of a column in a literal array or set, e.g.
+// input.resource.owner == ["a", "b"][_], and be negated with not. Anything else, including residuals that depend on
+// support modules, results in a SQLErr.
+//
+// A NULL column is treated as a field that is not set, which makes any expression over it undefined. A negated
+// expression is therefore true for rows in which one of its columns is NULL, so not input.resource.owner == "bob"
+// becomes (resources.owner IS NULL OR NOT (resources.owner = ?)) rather than a NOT that SQL would evaluate to NULL.
+// A field that is set is thus never null, so input.resource.owner == null becomes 1 = 0 and its negation 1 = 1,
+// while input.resource.owner != null becomes resources.owner IS NOT NULL.
+//
+// A residual that is always false translates to 1 = 0 and one that is always true to 1 = 1.
+func TranslateSQL(residual *Residual, tables []Table, placeholder Placeholder) (*SQLWhere, error) {
+	if len(residual.Support) > 0 {
+		return nil, NewSQLError("residual depends on support modules")
+	}
+
+	tr := &sqlTranslator{placeholder: placeholder}
+	for _, t := range tables {
+		ref, err := ast.ParseRef(t.Ref)
+		if err != nil {
+			return nil, NewSQLError(fmt.Sprintf("invalid reference %q for table %v: %v", t.Ref, t.Name, err))
+		}
+		tr.tables = append(tr.tables, sqlTable{Table: t, ref: ref})
+	}
+
+	if residual.Undefined() {
+		return &SQLWhere{Clause: "1 = 0"}, nil
+	}
+	if residual.Unconditional() {
+		return &SQLWhere{Clause: "1 = 1"}, nil
+	}
+
+	disjuncts := make([]string, 0, len(residual.Queries))
+	for _, q := range residual.Queries {
+		conjuncts := make([]string, 0, len(q))
+		for _, expr := range q {
+			s, err := tr.expr(expr)
+			if err != nil {
+				return nil, err
+			}
+			conjuncts = append(conjuncts, s)
+		}
+		disjuncts = append(disjuncts, join(conjuncts, " AND ", len(residual.Queries) > 1))
+	}
+
+	return &SQLWhere{Clause: join(disjuncts, " OR ", false), Args: tr.args}, nil
+}
+
+// join joins parts with sep, parenthesizing the result if there are several parts and paren is set
+func join(parts []string, sep string, paren bool) string {
+	s := strings.Join(parts, sep)
+	if paren && len(parts) > 1 {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+type sqlTable struct {
+	Table
+	ref ast.Ref
+}
+
+type sqlTranslator struct {
+	tables      []sqlTable
+	placeholder Placeholder
+	args        []interface{}
+}
+
+// sqlOperators maps the names of the Rego comparison operators to SQL
+var sqlOperators = map[string]string{
+	"eq":    "=",
+	"equal": "=",
+	"neq":   "<>",
+	"lt":    "<",
+	"lte":   "<=",
+	"gt":    ">",
+	"gte":   ">=",
+}
+
+// flipped maps each SQL operator to the one obtained by swapping its operands
+var flipped = map[string]string{
+	"=":  "=",
+	"<>": "<>",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+func (tr *sqlTranslator) expr(expr *ast.Expr) (string, error) {
+	if len(expr.With) > 0 {
+		return "", tr.unsupported(expr, "with modifiers are not supported")
+	}
+
+	var s string
+	var nullable []string
+	var err error
+	switch t := expr.Terms.(type) {
+	case *ast.Term:
+		s, nullable, err = tr.boolean(expr, t)
+	case []*ast.Term:
+		s, nullable, err = tr.call(expr)
+	default:
+		return "", tr.unsupported(expr, "unsupported expression")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !expr.Negated {
+		return s, nil
+	}
+	switch s {
+	case "1 = 0":
+		return "1 = 1", nil
+	case "1 = 1":
+		return "1 = 0", nil
+	}
+	if len(nullable) == 0 {
+		return "NOT (" + s + ")", nil
+	}
+	parts := make([]string, 0, len(nullable)+1)
+	for _, col := range nullable {
+		parts = append(parts, col+" IS NULL")
+	}
+	return "(" + strings.Join(append(parts, "NOT ("+s+")"), " OR ") + ")", nil
+}
+
+// boolean translates an expression consisting of a single term, which is true if the term is true. It also returns
+// the columns that make the expression NULL when they are.
+func (tr *sqlTranslator) boolean(expr *ast.Expr, term *ast.Term) (string, []string, error) {
+	switch v := term.Value.(type) {
+	case ast.Boolean:
+		if v {
+			return "1 = 1", nil, nil
+		}
+		return "1 = 0", nil, nil
+	case ast.Ref:
+		col, ok := tr.column(v)
+		if !ok {
+			return "", nil, tr.unsupported(expr, fmt.Sprintf("%v is not a declared column", v))
+		}
+		return col + " = " + tr.param(true), []string{col}, nil
+	}
+	return "", nil, tr.unsupported(expr, "unsupported expression")
+}
+
+// call translates a comparison. It also returns the columns that make the comparison NULL when they are.
+func (tr *sqlTranslator) call(expr *ast.Expr) (string, []string, error) {
+	op, ok := sqlOperators[expr.Operator().String()]
+	if !ok {
+		return "", nil, tr.unsupported(expr, fmt.Sprintf("unsupported operator %v", expr.Operator()))
+	}
+	operands := expr.Operands()
+	if len(operands) != 2 {
+		return "", nil, tr.unsupported(expr, "unsupported expression")
+	}
+	a, b := operands[0], operands[1]
+
+	// Put the column first, so that membership and null tests only need handling in one order
+	if _, ok := tr.columnTerm(a); !ok {
+		a, b, op = b, a, flipped[op]
+	}
+	col, ok := tr.columnTerm(a)
+	if !ok {
+		return "", nil, tr.unsupported(expr, "comparison does not involve a declared column")
+	}
+
+	if other, ok := tr.columnTerm(b); ok {
+		return col + " " + op + " " + other, []string{col, other}, nil
+	}
+
+	if elems, ok := members(b); ok {
+		if op != "=" {
+			return "", nil, tr.unsupported(expr, "membership can only be tested for equality")
+		}
+		if len(elems) == 0 {
+			return "1 = 0", nil, nil
+		}
+		params := make([]string, len(elems))
+		for i, elem := range elems {
+			param, err := tr.scalar(expr, elem)
+			if err != nil {
+				return "", nil, err
+			}
+			params[i] = param
+		}
+		return col + " IN (" + strings.Join(params, ", ") + ")", []string{col}, nil
+	}
+
+	if _, ok := b.Value.(ast.Null); ok {
+		switch op {
+		case "=":
+			return "1 = 0", nil, nil
+		case "<>":
+			return col + " IS NOT NULL", nil, nil
+		}
+		return "", nil, tr.unsupported(expr, "null can only be tested for equality")
+	}
+
+	param, err := tr.scalar(expr, b)
+	if err != nil {
+		return "", nil, err
+	}
+	return col + " " + op + " " + param, []string{col}, nil
+}
+
+// columnTerm returns the qualified column name of term if it refers to a declared column
+func (tr *sqlTranslator) columnTerm(term *ast.Term) (string, bool) {
+	ref, ok := term.Value.(ast.Ref)
+	if !ok {
+		return "", false
+	}
+	return tr.column(ref)
+}
+
+// column returns the qualified column name of ref if it refers to a declared column
+func (tr *sqlTranslator) column(ref ast.Ref) (string, bool) {
+	for _, t := range tr.tables {
+		if len(ref) != len(t.ref)+1 || !ref.HasPrefix(t.ref) {
+			continue
+		}
+		field, ok := ref[len(ref)-1].Value.(ast.String)
+		if !ok {
+			continue
+		}
+		if col, ok := t.Columns[string(field)]; ok {
+			return t.Name + "." + col, true
+		}
+	}
+	return "", false
+}
+
+// members returns the elements of term if it iterates over a literal array or set, e.g. ["a", "b"][_]
+func members(term *ast.Term) ([]*ast.Term, bool) {
+	ref, ok := term.Value.(ast.Ref)
+	if !ok || len(ref) != 2 {
+		return nil, false
+	}
+	if _, ok := ref[1].Value.(ast.Var); !ok {
+		return nil, false
+	}
+	switch v := ref[0].Value.(type) {
+	case ast.Array:
+		return v, true
+	case ast.Set:
+		return v.Slice(), true
+	}
+	return nil, false
+}
+
+// scalar adds term as a parameter and returns its placeholder
+func (tr *sqlTranslator) scalar(expr *ast.Expr, term *ast.Term) (string, error) {
+	switch v := term.Value.(type) {
+	case ast.String:
+		return tr.param(string(v)), nil
+	case ast.Boolean:
+		return tr.param(bool(v)), nil
+	case ast.Number:
+		n := json.Number(v)
+		if i, err := n.Int64(); err == nil {
+			return tr.param(i), nil
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return "", tr.unsupported(expr, fmt.Sprintf("number %v cannot be represented", v))
+		}
+		return tr.param(f), nil
+	}
+	return "", tr.unsupported(expr, fmt.Sprintf("%v is not a scalar", term))
+}
+
+// param adds value as a parameter and returns its placeholder
+func (tr *sqlTranslator) param(value interface{}) string {
+	tr.args = append(tr.args, value)
+	if tr.placeholder == PlaceholderDollar {
+		return fmt.Sprintf("$%d", len(tr.args))
+	}
+	return "?"
+}
+
+func (tr *sqlTranslator) unsupported(expr *ast.Expr, msg string) error {
+	return &SQLErr{Message: msg, Expr: expr.String()}
+}
@@ -0,0 +1,166 @@
+package rego
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+var sqlTables = []Table{
+	{
+		Name:    "posts",
+		Ref:     "input.post",
+		Columns: map[string]string{"owner": "owner_id", "public": "is_public", "score": "score", "editor": "editor_id", "deleted": "deleted_at"},
+	},
+}
+
+// sqlMembers is the term ["a", "b"][_]. Partial evaluation produces such terms but the parser does not accept them,
+// so queries refer to it as the variable members.
+var sqlMembers = ast.RefTerm(ast.ArrayTerm(ast.StringTerm("a"), ast.StringTerm("b")), ast.VarTerm("_")).Value
+
+// mustResidual builds a residual from queries; an empty query is always true
+func mustResidual(queries ...string) *Residual {
+	r := &Residual{}
+	for _, q := range queries {
+		if q == "" {
+			r.Queries = append(r.Queries, ast.Body{})
+			continue
+		}
+		body, err := ast.TransformVars(ast.MustParseBody(q), func(v ast.Var) (ast.Value, error) {
+			if v == "members" {
+				return sqlMembers, nil
+			}
+			return v, nil
+		})
+		if err != nil {
+			panic(err)
+		}
+		r.Queries = append(r.Queries, body.(ast.Body))
+	}
+	return r
+}
+
+func TestTranslateSQL(t *testing.T) {
+	tests := []struct {
+		note    string
+		queries []string
+		clause  string
+		args    []interface{}
+	}{
+		{"equality", []string{`input.post.owner = "bob"`}, "posts.owner_id = ?", []interface{}{"bob"}},
+		{"reversed", []string{`"bob" == input.post.owner`}, "posts.owner_id = ?", []interface{}{"bob"}},
+		{"not equal", []string{`input.post.owner != "bob"`}, "posts.owner_id <> ?", []interface{}{"bob"}},
+		{"comparison", []string{`3 < input.post.score`}, "posts.score > ?", []interface{}{int64(3)}},
+		{"float", []string{`input.post.score >= 1.5`}, "posts.score >= ?", []interface{}{1.5}},
+		{"boolean", []string{`input.post.public`}, "posts.is_public = ?", []interface{}{true}},
+		{"columns", []string{`input.post.owner = input.post.editor`}, "posts.owner_id = posts.editor_id", nil},
+		{"in", []string{`input.post.owner = members`}, "posts.owner_id IN (?, ?)", []interface{}{"a", "b"}},
+		{"null", []string{`input.post.deleted = null`}, "1 = 0", nil},
+		{"not equal null", []string{`input.post.deleted != null`}, "posts.deleted_at IS NOT NULL", nil},
+		{"not", []string{`not input.post.public`}, "(posts.is_public IS NULL OR NOT (posts.is_public = ?))", []interface{}{true}},
+		{"not equal negated", []string{`not input.post.owner != "bob"`}, "(posts.owner_id IS NULL OR NOT (posts.owner_id <> ?))", []interface{}{"bob"}},
+		{"not columns", []string{`not input.post.owner = input.post.editor`}, "(posts.owner_id IS NULL OR posts.editor_id IS NULL OR NOT (posts.owner_id = posts.editor_id))", nil},
+		{"not in", []string{`not input.post.owner = members`}, "(posts.owner_id IS NULL OR NOT (posts.owner_id IN (?, ?)))", []interface{}{"a", "b"}},
+		{"not null", []string{`not input.post.deleted = null`}, "1 = 1", nil},
+		{"not not equal null", []string{`not input.post.deleted != null`}, "NOT (posts.deleted_at IS NOT NULL)", nil},
+		{"not false", []string{`not false`}, "1 = 1", nil},
+		{"and", []string{`input.post.owner = "bob"; input.post.score > 2`}, "posts.owner_id = ? AND posts.score > ?", []interface{}{"bob", int64(2)}},
+		{"or", []string{`input.post.owner = "bob"; input.post.score > 2`, `input.post.public`}, "(posts.owner_id = ? AND posts.score > ?) OR posts.is_public = ?", []interface{}{"bob", int64(2), true}},
+		{"undefined", nil, "1 = 0", nil},
+		{"unconditional", []string{`input.post.public`, ``}, "1 = 1", nil},
+	}
+
+	for _, tc := range tests {
+		where, err := TranslateSQL(mustResidual(tc.queries...), sqlTables, PlaceholderQuestion)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.note, err)
+		}
+		if where.Clause != tc.clause {
+			t.Fatalf("%v: expected clause %q, got %q", tc.note, tc.clause, where.Clause)
+		}
+		if !reflect.DeepEqual(where.Args, tc.args) {
+			t.Fatalf("%v: expected args %v, got %v", tc.note, tc.args, where.Args)
+		}
+	}
+}
+
+func TestTranslateSQLPlaceholderDollar(t *testing.T) {
+	where, err := TranslateSQL(mustResidual(`input.post.owner = "bob"; input.post.score > 2`), sqlTables, PlaceholderDollar)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if where.Clause != "posts.owner_id = $1 AND posts.score > $2" {
+		t.Fatalf("unexpected clause %v", where.Clause)
+	}
+}
+
+func TestTranslateSQLUnsupported(t *testing.T) {
+	tests := []string{
+		`input.post.title = "x"`,
+		`startswith(input.post.owner, "b")`,
+		`input.post.owner = x`,
+		`input.post.owner < members`,
+		`input.post.owner = {"a": 1}`,
+		`input.post.public with input as {}`,
+	}
+	for _, q := range tests {
+		_, err := TranslateSQL(mustResidual(q), sqlTables, PlaceholderQuestion)
+		if !IsSQLErr(err) {
+			t.Fatalf("%v: expected SQLErr, got %v", q, err)
+		}
+	}
+
+	r := mustResidual(`input.post.public`)
+	r.Support = []*ast.Module{ast.MustParseModule("package partial\np = true")}
+	if _, err := TranslateSQL(r, sqlTables, PlaceholderQuestion); !IsSQLErr(err) {
+		t.Fatalf("expected SQLErr for support modules, got %v", err)
+	}
+}
+
+func TestPartialSQL(t *testing.T) {
+	cmp := setup(`
+	package test
+
+	allow { input.user == "admin" }
+	allow { input.post.owner == input.user }
+	allow { input.post.public }
+	`)
+
+	where, err := PartialSQL(cmp, "data.test.allow == true", sqlTables, map[string]interface{}{"user": "bob"}, nil, PlaceholderDollar)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !strings.Contains(where.Clause, "posts.owner_id = $") || !strings.Contains(where.Clause, "posts.is_public = $") {
+		t.Fatalf("unexpected clause %v", where.Clause)
+	}
+	if len(where.Args) != 2 {
+		t.Fatalf("expected 2 args, got %v", where.Args)
+	}
+
+	where, err = PartialSQL(cmp, "data.test.allow == true", sqlTables, map[string]interface{}{"user": "admin"}, nil, PlaceholderDollar)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if where.Clause != "1 = 1" {
+		t.Fatalf("expected unconditional clause, got %v", where.Clause)
+	}
+}
+
+func TestPartialSQLNegationNull(t *testing.T) {
+	cmp := setup(`
+	package test
+
+	allow { not input.post.owner == "bob" }
+	`)
+
+	// a row whose owner is NULL has no owner, so it is not owned by bob and must be allowed
+	where, err := PartialSQL(cmp, "data.test.allow == true", sqlTables, nil, nil, PlaceholderQuestion)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if where.Clause != "(posts.owner_id IS NULL OR NOT (posts.owner_id = ?))" {
+		t.Fatalf("unexpected clause %v", where.Clause)
+	}
+}
@@ -0,0 +1,74 @@
+package rego
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// EncodeModule streams module to w in the given format. The output of FormatJson can be read by
+// DeserializeModuleJson and that of FormatGob by DeserializeModuleGob. Streams are never wrapped in an envelope, as
+// the digest would require the whole payload to be buffered. module is not modified, so EncodeModule may be called
+// concurrently on a shared module.
+func EncodeModule(w io.Writer, module *ast.Module, format Format) error {
+	ew := NewErrWriter(w)
+	out := errWriterStream{ew}
+
+	var err error
+	switch format {
+	case FormatJson:
+		err = json.NewEncoder(out).Encode(*module)
+	case FormatGob:
+		// sets and objects are replaced on a copy so that the caller's module is left intact
+		cpy := module.Copy()
+		removeModuleFromRules(cpy)
+		toGobValues(cpy)
+		err = gob.NewEncoder(out).Encode(*cpy)
+	default:
+		return fmt.Errorf("unknown format %v", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	ew.Flush()
+	return ew.Error()
+}
+
+// DecodeModule reads a module in the given format from r.
+func DecodeModule(r io.Reader, format Format) (*ast.Module, error) {
+	var module ast.Module
+	var err error
+	switch format {
+	case FormatJson:
+		err = json.NewDecoder(r).Decode(&module)
+	case FormatGob:
+		if err = gob.NewDecoder(r).Decode(&module); err == nil {
+			fromGobValues(&module)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %v", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	addModuleToRules(&module)
+	return &module, nil
+}
+
+// errWriterStream adapts an ErrWriter to io.Writer so that encoders can write through it. Once a write fails every
+// subsequent write reports the same error.
+type errWriterStream struct {
+	ew *ErrWriter
+}
+
+func (s errWriterStream) Write(p []byte) (int, error) {
+	s.ew.Write(p)
+	if err := s.ew.Error(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
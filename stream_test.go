@@ -0,0 +1,58 @@
+package rego
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// failingWriter fails every write after the first limit bytes
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		return 0, errors.New("disk full")
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestEncodeDecodeModule(t *testing.T) {
+	for _, format := range []Format{FormatJson, FormatGob} {
+		mod := mustParse(t, "test", envelopePolicy)
+		buf := new(bytes.Buffer)
+		if err := EncodeModule(buf, mod, format); err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		result, err := DecodeModule(buf, format)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		if len(result.Rules) != 2 {
+			t.Fatalf("%v: expected 2 rules, got %v", format, len(result.Rules))
+		}
+		if result.Rules[0].Module != result {
+			t.Fatalf("%v: expected rules to reference their module", format)
+		}
+	}
+}
+
+func TestEncodeModuleCompatible(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := EncodeModule(buf, mustParse(t, "test", envelopePolicy), FormatJson); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := DeserializeModuleJson(buf.Bytes()); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestEncodeModuleWriteError(t *testing.T) {
+	mod := mustParse(t, "test", envelopePolicy)
+	err := EncodeModule(&failingWriter{limit: 10}, mod, FormatJson)
+	if err == nil || err.Error() != "disk full" {
+		t.Fatalf("expected write error, got %v", err)
+	}
+}
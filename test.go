@@ -95,20 +95,20 @@ type TestCase struct {
 	Expected interface{}
 }
 
-// RunTestCase runs the given test with the given inputs and data document. It annotates the test with note.
+// RunTestCase runs the given test with the given input and data document. It annotates the test with note.
 // To check for equality, under the hood test.Expected is converted to a JSON object and the result of the rego
 // query is also converted into a JSON object. These two objects are then tested for deep equality. If the
 // expected value cannot be converted to JSON, this function panics.
-func (test *TestCase) Run(t *testing.T, inputs, data map[string]interface{}) {
+func (test *TestCase) Run(t *testing.T, input interface{}, data map[string]interface{}) {
 	t.Run(test.Note, func(t2 *testing.T) {
-		err := runTestCase(inputs, data, test)
+		err := runTestCase(input, data, test)
 		if err != nil {
 			t2.Fatalf(err.Error())
 		}
 	})
 }
 
-func runTestCase(inputs, data map[string]interface{}, test *TestCase) error {
+func runTestCase(input interface{}, data map[string]interface{}, test *TestCase) error {
 	pkg := "testing"
 	compiler, err := compileRules(pkg, test.Rules)
 	if exp, ok := test.Expected.(error); err != nil && ok {
@@ -128,12 +128,12 @@ func runTestCase(inputs, data map[string]interface{}, test *TestCase) error {
 	}
 
 	path := "data." + pkg
-	return assertWithPath(compiler, inputs, store, test.Target, path, test.Expected)
+	return assertWithPath(compiler, input, store, test.Target, path, test.Expected)
 }
 
-// RunTestFile ensures that the outcome of rule in file with inputs and data as provided is equal to expected. The
+// RunTestFile ensures that the outcome of rule in file with input and data as provided is equal to expected. The
 // comparison is done in the same way as TestCase.Run().
-func RunTestFile(t *testing.T, inputs, data map[string]interface{}, file, rule, note string, expected interface{}) {
+func RunTestFile(t *testing.T, input interface{}, data map[string]interface{}, file, rule, note string, expected interface{}) {
 	module, err := ParseBytes("test", []byte(file))
 	if err != nil {
 		t.Fatalf(err.Error())
@@ -149,17 +149,17 @@ func RunTestFile(t *testing.T, inputs, data map[string]interface{}, file, rule,
 		store = inmem.NewFromObject(data)
 	}
 
-	assertWithPath(cmp, inputs, store, rule, module.Package.Path.String(), expected)
+	assertWithPath(cmp, input, store, rule, module.Package.Path.String(), expected)
 }
 
-func assertWithPath(compiler *ast.Compiler, inputs map[string]interface{}, store storage.Store,
+func assertWithPath(compiler *ast.Compiler, input interface{}, store storage.Store,
 	rule, path string, expected interface{}) error {
 
 	q := fmt.Sprintf("%v.%v", path, rule)
 
 	switch e := expected.(type) {
 	case error:
-		rs, err := Query(compiler, q, inputs, &store)
+		rs, err := Query(compiler, q, input, &store)
 		if err == nil {
 			return fmt.Errorf("expected error but got: %v", rs)
 		}
@@ -168,7 +168,7 @@ func assertWithPath(compiler *ast.Compiler, inputs map[string]interface{}, store
 		}
 	default:
 
-		rs, err := Query(compiler, q, inputs, &store)
+		rs, err := Query(compiler, q, input, &store)
 
 		if err != nil {
 			return fmt.Errorf("unexpected error: %v", err)